@@ -0,0 +1,134 @@
+package petitcrawler
+
+
+import (
+    "encoding/csv"
+    "net/url"
+    "os"
+    "path"
+    "strconv"
+    "strings"
+)
+
+
+// CSVOutput writes the sitemap as three related tables, for spreadsheets: <name>-pages, <name>-links and
+// <name>-assets, where <name> is the crawler's Filename without its extension.
+// The pages table has a row per page, the links table a row per <a href> on a page (source, target,
+// anchor text, internal or external), and the assets table a row per asset of a page, with its type.
+type CSVOutput struct {
+    Comma rune          // field separator, ',' for CSV and '\t' for TSV
+}
+
+func ( out *CSVOutput ) Extension() string {
+    if out.Comma == '\t' {
+        return ".tsv"
+    }
+    return ".csv"
+}
+
+func ( out *CSVOutput ) Begin( crawler *SingleCrawler ) error { return nil }
+func ( out *CSVOutput ) Page( crawler *SingleCrawler, page Page ) error { return nil }
+
+
+// Headers of the CSV tables
+var (
+    CSV_PAGES_HEADER = []string{ "url", "final_url", "status", "content_type", "title", "depth", "parent", "content_length", "links", "assets", "response_time_ms" }
+    CSV_LINKS_HEADER = []string{ "source", "target", "anchor_text", "type" }
+    CSV_ASSETS_HEADER = []string{ "page", "asset", "type" }
+)
+
+
+// CSVFiles returns the names of the pages, links and assets tables written for filename
+func ( out *CSVOutput ) CSVFiles( filename string ) (pages, links, assets string) {
+    stem := strings.TrimSuffix( filename, path.Ext(filename) )
+    return stem + "-pages" + out.Extension(), stem + "-links" + out.Extension(), stem + "-assets" + out.Extension()
+}
+
+
+func ( out *CSVOutput ) End( crawler *SingleCrawler ) error {
+
+    comma := out.Comma
+    if comma == 0 {
+        comma = ','
+    }
+    pagesFile, linksFile, assetsFile := out.CSVFiles( crawler.Filename )
+    var files []*os.File
+    var writers []*csv.Writer
+    defer func() {
+        for _, file := range files {
+            file.Close()
+        }
+    }()
+    for _, name := range []string{ pagesFile, linksFile, assetsFile } {
+        file, err := os.Create( name )
+        if err != nil {
+            return err
+        }
+        files = append( files, file )
+        w := csv.NewWriter( file )
+        w.Comma = comma
+        writers = append( writers, w )
+    }
+    pages, links, assets := writers[0], writers[1], writers[2]
+    pages.Write( CSV_PAGES_HEADER )
+    links.Write( CSV_LINKS_HEADER )
+    assets.Write( CSV_ASSETS_HEADER )
+
+    err := crawler.EachPage( func( p Page ) error {
+        err := pages.Write( []string{ p.MyUrl, p.FinalURL, strconv.Itoa(p.Status), p.ContentType, p.Title,
+            strconv.Itoa(p.Depth), p.Parent, strconv.FormatInt(p.ContentLength, 10), strconv.Itoa(len(p.Anchors)),
+            strconv.Itoa(len(p.Assets)), strconv.FormatInt(p.ResponseTime.Milliseconds(), 10) } )
+        for _, a := range p.Anchors {
+            kind := "external"
+            if a.Internal {
+                kind = "internal"
+            }
+            if err == nil {
+                err = links.Write( []string{ p.MyUrl, a.URL, a.Text, kind } )
+            }
+        }
+        for _, asset := range p.AssetURLs {
+            if err == nil {
+                err = assets.Write( []string{ p.MyUrl, asset, AssetType(asset) } )
+            }
+        }
+        return err
+    })
+
+    for i, w := range writers {
+        w.Flush()
+        if err == nil {
+            err = w.Error()
+        }
+        if cerr := files[i].Close(); err == nil {
+            err = cerr
+        }
+    }
+    files = nil
+    return err
+}
+
+
+// AssetType guesses what kind of asset link is from its extension: image, script, stylesheet, font,
+// javascript (a javascript: URL) or other
+func AssetType( link string ) string {
+
+    if strings.HasPrefix( strings.ToLower(link), "javascript:" ) {
+        return "javascript"
+    }
+    p := link
+    if u, err := url.Parse( link ); err == nil {
+        p = u.Path
+    }
+    switch strings.ToLower( path.Ext(p) ) {
+        case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".ico", ".bmp", ".avif":
+            return "image"
+        case ".js", ".mjs":
+            return "script"
+        case ".css":
+            return "stylesheet"
+        case ".woff", ".woff2", ".ttf", ".otf", ".eot":
+            return "font"
+    }
+    return "other"
+}
@@ -0,0 +1,172 @@
+package petitcrawler
+
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+    bolt "go.etcd.io/bbolt"
+    "github.com/golang/glog"
+)
+
+
+// Default time between checkpoints of a crawl with a state file
+var DEFAULT_CHECKPOINT_EVERY = 30 * time.Second
+
+// Version of the checkpoint saved in a state file, bumped when its fields change
+const CHECKPOINT_VERSION = 2
+
+
+// checkpoint is the crawler's state saved with its Storage, that the Storage doesn't already hold
+type checkpoint struct {
+
+    Version int
+    Site string                     // the start URL
+    Time time.Time                  // when the checkpoint was taken
+    Reason StopReason               // why the crawl stopped, empty while it's running
+    Dedup DedupMode                 // the stored dedup keys depend on it, so a resumed crawl keeps it
+    ScopeMode ScopeMode             // what was crawled, so a resumed crawl keeps to the same site
+    AllowedHosts []string
+    Include []string                // include and exclude rules, as for ParseRule
+    Exclude []string
+    Normalizer Normalizer           // the visited set holds URLs normalized with it
+    MaxDepth int
+    Excluded map[string]string
+    Failed []Fetch
+    NonHTML []Fetch
+
+}
+
+
+// checkpoint saves the crawler's state with its Storage, if the Storage can checkpoint
+func ( crawler *SingleCrawler ) checkpoint() error {
+
+    store, ok := crawler.Storage.(Checkpointer)
+    if ok == false {
+        return nil
+    }
+    cp := checkpoint{
+        Version: CHECKPOINT_VERSION,
+        Site: crawler.Site.String(),
+        Time: time.Now(),
+        Reason: crawler.Reason,
+        Dedup: crawler.Dedup,
+        ScopeMode: crawler.ScopeMode,
+        AllowedHosts: crawler.AllowedHosts,
+        Normalizer: *crawler.Normalizer,
+        MaxDepth: crawler.MaxDepth,
+        Excluded: crawler.Excluded,
+        Failed: crawler.Failed,
+        NonHTML: crawler.NonHTML,
+    }
+    for _, rule := range crawler.IncludeRules {
+        cp.Include = append( cp.Include, rule.String() )
+    }
+    for _, rule := range crawler.ExcludeRules {
+        cp.Exclude = append( cp.Exclude, rule.String() )
+    }
+    state, err := json.Marshal( cp )
+    if err != nil {
+        return err
+    }
+    glog.Info("Checkpointing crawl")
+    return store.Checkpoint( state )
+}
+
+
+// readCheckpoint reads the checkpoint and strategy of the state file at path, without opening it for writing
+func readCheckpoint( path string ) (checkpoint, Strategy, error) {
+
+    var cp checkpoint
+    var strategy Strategy
+    db, err := bolt.Open( path, 0644, &bolt.Options{ Timeout: time.Second, ReadOnly: true } )
+    if err != nil {
+        return cp, strategy, errors.New( fmt.Sprintf("Unable to open state file %s. Error is %s.", path, err))
+    }
+    defer db.Close()
+
+    err = db.View( func( tx *bolt.Tx ) error {
+        meta := tx.Bucket( metaBucket )
+        if meta == nil || meta.Get( checkpointKey ) == nil {
+            return errors.New("it has no checkpoint")
+        }
+        if s := meta.Get( strategyKey ); s != nil {
+            strategy = Strategy( s[0] )
+        }
+        return json.Unmarshal( meta.Get( checkpointKey ), &cp )
+    })
+    if err == nil && cp.Version != CHECKPOINT_VERSION {
+        err = errors.New( fmt.Sprintf("checkpoint version %d is not supported", cp.Version))
+    }
+    if err != nil {
+        return cp, strategy, errors.New( fmt.Sprintf("Unable to read state file %s. Error is %s.", path, err))
+    }
+    return cp, strategy, nil
+}
+
+
+// Resume creates a crawler that continues the crawl checkpointed in the state file at path.
+// What is crawled comes from the state file: the start URL, strategy, dedup mode, scope, include and
+// exclude rules, normalizer and max depth, even if opts set them. The other settings come from opts.
+// Links that were being crawled when it stopped are queued again, pages already collected are kept
+// and not fetched again. The crawl keeps checkpointing to path.
+func Resume( path string, opts ...Option ) (*SingleCrawler, error) {
+
+    cp, strategy, err := readCheckpoint( path )
+    if err != nil {
+        glog.Error( err )
+        return nil, err
+    }
+
+    opts = append( []Option{ WithCheckpoint(path, DEFAULT_CHECKPOINT_EVERY) }, opts... )
+    opts = append( opts, WithStrategy(strategy), restore(cp) )
+    crawler, err := NewSingleCrawler( cp.Site, opts... )
+    if err != nil {
+        return nil, err
+    }
+    if crawler.CheckpointFile != path {
+        crawler.Close()
+        return nil, errors.New("Can't resume from one state file and checkpoint to another.")
+    }
+
+    n, err := crawler.Storage.Requeue()
+    if err == nil {
+        crawler.NumPages = 0
+        err = crawler.Storage.Pages( func( Page ) error {
+            crawler.NumPages++
+            return nil
+        })
+    }
+    if err != nil {
+        crawler.Close()
+        return nil, errors.New( fmt.Sprintf("Unable to resume from %s. Error is %s.", path, err))
+    }
+    glog.Info( fmt.Sprintf("Resuming crawl of %s from %s: %d pages collected, %d links queued (%d were in flight).",
+        cp.Site, cp.Time, crawler.NumPages, crawler.Storage.Queued(), n) )
+
+    crawler.Excluded = cp.Excluded
+    crawler.Failed = cp.Failed
+    crawler.NonHTML = cp.NonHTML
+    crawler.resumed = true
+    return crawler, nil
+}
+
+
+// restore sets what is crawled to the settings saved in cp, replacing any set by other options
+func restore( cp checkpoint ) Option {
+    return func( crawler *SingleCrawler ) error {
+        crawler.Dedup = cp.Dedup
+        crawler.ScopeMode = cp.ScopeMode
+        crawler.AllowedHosts = cp.AllowedHosts
+        crawler.IncludeRules = nil
+        crawler.ExcludeRules = nil
+        normalizer := cp.Normalizer
+        crawler.Normalizer = &normalizer
+        crawler.MaxDepth = cp.MaxDepth
+        if err := WithInclude( cp.Include... )( crawler ); err != nil {
+            return err
+        }
+        return WithExclude( cp.Exclude... )( crawler )
+    }
+}
@@ -0,0 +1,98 @@
+package petitcrawler
+
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strings"
+    "golang.org/x/net/html"
+)
+
+
+// DedupMode picks what makes two collected pages the same page
+type DedupMode int
+
+const (
+    DedupURL DedupMode = iota       // pages with the same normalized URL, after redirects
+    DedupBody                       // pages with the same response body
+    DedupText                       // pages with the same visible text, ignoring markup and whitespace
+)
+
+
+// Elements whose text isn't visible on the page
+var hiddenElements = map[string]bool{ "script": true, "style": true, "noscript": true, "template": true, "head": true }
+
+
+// VisibleText returns the text of the document a reader would see, with runs of whitespace collapsed to one space
+func VisibleText( n *html.Node ) string {
+
+    var words []string
+    var walk func( n *html.Node )
+    walk = func( n *html.Node ) {
+        if n.Type == html.ElementNode && hiddenElements[n.Data] {
+            return
+        }
+        if n.Type == html.TextNode {
+            words = append( words, strings.Fields( n.Data )... )
+        }
+        for c := n.FirstChild; c != nil; c = c.NextSibling {
+            walk(c)
+        }
+    }
+    if n != nil {
+        walk(n)
+    }
+    return strings.Join( words, " " )
+}
+
+
+// hashHex returns the hex sha256 of b
+func hashHex( b []byte ) string {
+    sum := sha256.Sum256( b )
+    return hex.EncodeToString( sum[:] )
+}
+
+
+// Hash of the visible text of a page with none, ex: only images or a script that builds the page
+var emptyTextHash = hashHex( []byte("") )
+
+
+// dedupKey returns what page is made unique by in mode.
+// Pages without visible text can't be told apart by it, so they are made unique by URL.
+// Pages are at their URL after redirects, so two links redirecting to the same page are the same page.
+func dedupKey( mode DedupMode, page Page, normalizer *Normalizer ) string {
+
+    switch mode {
+        case DedupBody:
+            return page.BodyHash
+        case DedupText:
+            if page.TextHash != "" && page.TextHash != emptyTextHash {
+                return page.TextHash
+            }
+    }
+    link := page.MyUrl
+    if page.FinalURL != "" {
+        link = page.FinalURL
+    }
+    if key, err := normalizer.Normalize( link ); err == nil {
+        return key
+    }
+    return link
+}
+
+
+// ParseDedupMode turns a name (url, body, text) into a DedupMode
+func ParseDedupMode( name string ) (DedupMode, error) {
+
+    switch strings.ToLower(name) {
+        case "url":
+            return DedupURL, nil
+        case "body":
+            return DedupBody, nil
+        case "text":
+            return DedupText, nil
+    }
+    return DedupURL, errors.New( fmt.Sprintf("Unknown dedup mode %s, must be url, body or text.", name))
+}
@@ -0,0 +1,361 @@
+package petitcrawler
+
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "time"
+    bolt "go.etcd.io/bbolt"
+)
+
+
+// Buckets of the DiskStorage database. Sets each get their own bucket, under setsBucket.
+var (
+    frontierBucket = []byte("frontier")
+    inflightBucket = []byte("inflight")
+    setsBucket = []byte("sets")
+    pagesBucket = []byte("pages")
+    metaBucket = []byte("meta")
+)
+
+// Keys of the meta bucket
+var (
+    strategyKey = []byte("strategy")
+    checkpointKey = []byte("checkpoint")
+)
+
+
+// DiskStorage keeps the crawl state in a bbolt database file, so a crawl isn't limited by memory.
+// The frontier is kept in key order, with keys built from the strategy, so Pop is a read of the first key.
+// Writes aren't synced to disk one by one, for speed: they survive the crawler crashing,
+// but not the machine, until Checkpoint or Close.
+type DiskStorage struct {
+
+    db *bolt.DB
+    strategy Strategy
+    score ScoreFunc
+    queued int                  // cached counts, bucket stats are slow on large buckets
+    counts map[string]int
+
+}
+
+
+// NewDiskStorage opens (or creates) the database at path, handing out links by strategy,
+// scored by score for BestFirst. An existing database keeps its frontier, sets and pages,
+// and must be opened with the strategy it was created with.
+func NewDiskStorage( path string, strategy Strategy, score ScoreFunc ) (*DiskStorage, error) {
+
+    if score == nil {
+        score = ScoreShortestPath
+    }
+    db, err := bolt.Open( path, 0644, &bolt.Options{ Timeout: time.Second, NoSync: true } )
+    if err != nil {
+        return nil, errors.New( fmt.Sprintf("Unable to open storage %s. Error is %s.", path, err))
+    }
+    store := &DiskStorage{ db: db, strategy: strategy, score: score, counts: make( map[string]int ) }
+
+    err = db.Update( func( tx *bolt.Tx ) error {
+        for _, name := range [][]byte{ frontierBucket, inflightBucket, setsBucket, pagesBucket, metaBucket } {
+            if _, err := tx.CreateBucketIfNotExists( name ); err != nil {
+                return err
+            }
+        }
+
+        // The frontier's keys depend on the strategy, so it can't change
+        meta := tx.Bucket( metaBucket )
+        if s := meta.Get( strategyKey ); s == nil {
+            if err := meta.Put( strategyKey, []byte{ byte(strategy) } ); err != nil {
+                return err
+            }
+        } else if Strategy(s[0]) != strategy {
+            return errors.New( fmt.Sprintf("it was created with strategy %d, not %d", s[0], strategy))
+        }
+
+        store.queued = tx.Bucket( frontierBucket ).Stats().KeyN
+        return tx.Bucket( setsBucket ).ForEach( func( name, _ []byte ) error {
+            store.counts[string(name)] = tx.Bucket( setsBucket ).Bucket( name ).Stats().KeyN
+            return nil
+        })
+    })
+    if err != nil {
+        db.Close()
+        return nil, errors.New( fmt.Sprintf("Unable to set up storage %s. Error is %s.", path, err))
+    }
+    return store, nil
+}
+
+
+// frontierKey orders a link in the frontier bucket by the strategy, seq breaking ties in arrival order
+func ( store *DiskStorage ) frontierKey( link Link, seq uint64 ) []byte {
+
+    switch store.strategy {
+        case DepthFirst:
+            return binary.BigEndian.AppendUint64( nil, math.MaxUint64 - seq )
+        case BestFirst:
+            // Flip the bits of the negated score so byte order is float order, highest score first
+            bits := math.Float64bits( -store.score(link) )
+            if bits & (1 << 63) != 0 {
+                bits = ^bits
+            } else {
+                bits |= 1 << 63
+            }
+            key := binary.BigEndian.AppendUint64( nil, bits )
+            return binary.BigEndian.AppendUint64( key, seq )
+    }
+    key := binary.BigEndian.AppendUint32( nil, uint32(link.Depth) )
+    return binary.BigEndian.AppendUint64( key, seq )
+}
+
+
+func ( store *DiskStorage ) Push( link Link ) error {
+
+    value, err := json.Marshal( link )
+    if err != nil {
+        return err
+    }
+    err = store.db.Update( func( tx *bolt.Tx ) error {
+        b := tx.Bucket( frontierBucket )
+        seq, err := b.NextSequence()
+        if err != nil {
+            return err
+        }
+        return b.Put( store.frontierKey( link, seq ), value )
+    })
+    if err == nil {
+        store.queued++
+    }
+    return err
+}
+
+
+func ( store *DiskStorage ) Peek() (Link, bool, error) {
+
+    var link Link
+    found := false
+    err := store.db.View( func( tx *bolt.Tx ) error {
+        k, v := tx.Bucket( frontierBucket ).Cursor().First()
+        if k == nil {
+            return nil
+        }
+        found = true
+        return json.Unmarshal( v, &link )
+    })
+    return link, found, err
+}
+
+
+func ( store *DiskStorage ) Pop() (Link, bool, error) {
+
+    var link Link
+    found := false
+    err := store.db.Update( func( tx *bolt.Tx ) error {
+        c := tx.Bucket( frontierBucket ).Cursor()
+        k, v := c.First()
+        if k == nil {
+            return nil
+        }
+        if err := json.Unmarshal( v, &link ); err != nil {
+            return err
+        }
+        found = true
+        if err := tx.Bucket( inflightBucket ).Put( []byte(link.URL), v ); err != nil {
+            return err
+        }
+        return c.Delete()
+    })
+    if found && err == nil {
+        store.queued--
+    }
+    return link, found && err == nil, err
+}
+
+
+func ( store *DiskStorage ) Queued() int {
+    return store.queued
+}
+
+
+func ( store *DiskStorage ) Done( url string ) error {
+
+    return store.db.Update( func( tx *bolt.Tx ) error {
+        return tx.Bucket( inflightBucket ).Delete( []byte(url) )
+    })
+}
+
+
+func ( store *DiskStorage ) Requeue() (int, error) {
+
+    n := 0
+    err := store.db.Update( func( tx *bolt.Tx ) error {
+        frontier := tx.Bucket( frontierBucket )
+        c := tx.Bucket( inflightBucket ).Cursor()
+        for k, v := c.First(); k != nil; k, v = c.First() {
+            var link Link
+            if err := json.Unmarshal( v, &link ); err != nil {
+                return err
+            }
+            seq, err := frontier.NextSequence()
+            if err != nil {
+                return err
+            }
+            if err = frontier.Put( store.frontierKey( link, seq ), v ); err != nil {
+                return err
+            }
+            if err = c.Delete(); err != nil {
+                return err
+            }
+            n++
+        }
+        return nil
+    })
+    if err != nil {
+        return 0, err
+    }
+    store.queued += n
+    return n, nil
+}
+
+
+func ( store *DiskStorage ) Mark( set, key string ) (bool, error) {
+
+    added := false
+    err := store.db.Update( func( tx *bolt.Tx ) error {
+        b, err := tx.Bucket( setsBucket ).CreateBucketIfNotExists( []byte(set) )
+        if err != nil {
+            return err
+        }
+        if b.Get( []byte(key) ) != nil {
+            return nil
+        }
+        added = true
+        return b.Put( []byte(key), []byte{} )
+    })
+    if added && err == nil {
+        store.counts[set]++
+    }
+    return added && err == nil, err
+}
+
+
+func ( store *DiskStorage ) Seen( set, key string ) (bool, error) {
+
+    seen := false
+    err := store.db.View( func( tx *bolt.Tx ) error {
+        if b := tx.Bucket( setsBucket ).Bucket( []byte(set) ); b != nil {
+            seen = b.Get( []byte(key) ) != nil
+        }
+        return nil
+    })
+    return seen, err
+}
+
+
+func ( store *DiskStorage ) Get( set, key string ) (string, bool, error) {
+
+    var value string
+    found := false
+    err := store.db.View( func( tx *bolt.Tx ) error {
+        if b := tx.Bucket( setsBucket ).Bucket( []byte(set) ); b != nil {
+            if v := b.Get( []byte(key) ); v != nil {
+                value = string(v)
+                found = true
+            }
+        }
+        return nil
+    })
+    return value, found, err
+}
+
+
+func ( store *DiskStorage ) Put( set, key, value string ) error {
+
+    added := false
+    err := store.db.Update( func( tx *bolt.Tx ) error {
+        b, err := tx.Bucket( setsBucket ).CreateBucketIfNotExists( []byte(set) )
+        if err != nil {
+            return err
+        }
+        added = b.Get( []byte(key) ) == nil
+        return b.Put( []byte(key), []byte(value) )
+    })
+    if added && err == nil {
+        store.counts[set]++
+    }
+    return err
+}
+
+
+func ( store *DiskStorage ) Count( set string ) int {
+    return store.counts[set]
+}
+
+
+func ( store *DiskStorage ) AddPage( page Page ) error {
+
+    value, err := json.Marshal( page )
+    if err != nil {
+        return err
+    }
+    return store.db.Update( func( tx *bolt.Tx ) error {
+        b := tx.Bucket( pagesBucket )
+        seq, err := b.NextSequence()
+        if err != nil {
+            return err
+        }
+        return b.Put( binary.BigEndian.AppendUint64( nil, seq ), value )
+    })
+}
+
+
+func ( store *DiskStorage ) Pages( fn func(Page) error ) error {
+
+    return store.db.View( func( tx *bolt.Tx ) error {
+        return tx.Bucket( pagesBucket ).ForEach( func( _, v []byte ) error {
+            var page Page
+            if err := json.Unmarshal( v, &page ); err != nil {
+                return err
+            }
+            return fn( page )
+        })
+    })
+}
+
+
+// Checkpoint saves state, and syncs the database to disk
+func ( store *DiskStorage ) Checkpoint( state []byte ) error {
+
+    err := store.db.Update( func( tx *bolt.Tx ) error {
+        return tx.Bucket( metaBucket ).Put( checkpointKey, state )
+    })
+    if err != nil {
+        return err
+    }
+    return store.db.Sync()
+}
+
+
+func ( store *DiskStorage ) LoadCheckpoint() ([]byte, error) {
+
+    var state []byte
+    err := store.db.View( func( tx *bolt.Tx ) error {
+        if v := tx.Bucket( metaBucket ).Get( checkpointKey ); v != nil {
+            state = append( []byte{}, v... )
+        }
+        return nil
+    })
+    return state, err
+}
+
+
+// Close syncs the database to disk and closes it
+func ( store *DiskStorage ) Close() error {
+
+    if err := store.db.Sync(); err != nil {
+        store.db.Close()
+        return err
+    }
+    return store.db.Close()
+}
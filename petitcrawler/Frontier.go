@@ -0,0 +1,159 @@
+package petitcrawler
+
+
+import (
+    "container/heap"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+
+// Strategy is the order the frontier hands out links in
+type Strategy int
+
+const (
+    BreadthFirst Strategy = iota    // shallowest links first, in the order they were found
+    DepthFirst                      // most recently found links first
+    BestFirst                       // highest scoring links first, ties in the order they were found
+)
+
+
+// ScoreFunc rates a link for BestFirst, higher scores are crawled first
+type ScoreFunc func( link Link ) float64
+
+
+// ScoreShortestPath prefers links with the fewest path segments, ex: /docs before /docs/a/b
+func ScoreShortestPath( link Link ) float64 {
+
+    u, err := url.Parse( link.URL )
+    if err != nil {
+        return 0
+    }
+    return -float64( strings.Count( strings.Trim(u.EscapedPath(), "/"), "/" ) )
+}
+
+
+// Frontier holds the links waiting to be crawled, and picks the next one by its Strategy.
+// It is owned by the controller loop, so it isn't safe for concurrent use.
+type Frontier struct {
+
+    Strategy Strategy
+    Score ScoreFunc         // used by BestFirst, nil means ScoreShortestPath
+    items frontierHeap
+    seq int                 // count of links pushed, to keep ties in arrival order
+
+}
+
+
+// NewFrontier creates an empty Frontier handing out links by strategy, scored by score for BestFirst
+func NewFrontier( strategy Strategy, score ScoreFunc ) *Frontier {
+
+    if score == nil {
+        score = ScoreShortestPath
+    }
+    frontier := &Frontier{ Strategy: strategy, Score: score }
+    frontier.items.less = frontier.less
+    return frontier
+}
+
+
+// Push adds a link to the frontier
+func ( frontier *Frontier ) Push( link Link ) {
+
+    item := frontierItem{ link: link, seq: frontier.seq }
+    if frontier.Strategy == BestFirst {
+        item.score = frontier.Score( link )
+    }
+    frontier.seq++
+    heap.Push( &frontier.items, item )
+}
+
+
+// Peek returns the link Pop would return, without removing it. ok is false if the frontier is empty.
+func ( frontier *Frontier ) Peek() (link Link, ok bool) {
+
+    if len(frontier.items.items) == 0 {
+        return link, false
+    }
+    return frontier.items.items[0].link, true
+}
+
+
+// Pop removes and returns the next link to crawl. ok is false if the frontier is empty.
+func ( frontier *Frontier ) Pop() (link Link, ok bool) {
+
+    if len(frontier.items.items) == 0 {
+        return link, false
+    }
+    return heap.Pop( &frontier.items ).(frontierItem).link, true
+}
+
+
+// Len is the number of links waiting
+func ( frontier *Frontier ) Len() int {
+    return len(frontier.items.items)
+}
+
+
+// less orders two waiting links by the frontier's strategy
+func ( frontier *Frontier ) less( a, b frontierItem ) bool {
+
+    switch frontier.Strategy {
+        case DepthFirst:
+            return a.seq > b.seq
+        case BestFirst:
+            if a.score != b.score {
+                return a.score > b.score
+            }
+        default:
+            if a.link.Depth != b.link.Depth {
+                return a.link.Depth < b.link.Depth
+            }
+    }
+    return a.seq < b.seq
+}
+
+
+// ParseStrategy turns a name (bfs, dfs, best) into a Strategy
+func ParseStrategy( name string ) (Strategy, error) {
+
+    switch strings.ToLower(name) {
+        case "bfs":
+            return BreadthFirst, nil
+        case "dfs":
+            return DepthFirst, nil
+        case "best":
+            return BestFirst, nil
+    }
+    return BreadthFirst, errors.New( fmt.Sprintf("Unknown strategy %s, must be bfs, dfs or best.", name))
+}
+
+
+// frontierItem is a waiting link, with what it is ordered by
+type frontierItem struct {
+    link Link
+    score float64
+    seq int
+}
+
+
+// frontierHeap implements heap.Interface over the waiting links
+type frontierHeap struct {
+    items []frontierItem
+    less func( a, b frontierItem ) bool
+}
+
+func ( h *frontierHeap ) Len() int { return len(h.items) }
+func ( h *frontierHeap ) Less( i, j int ) bool { return h.less( h.items[i], h.items[j] ) }
+func ( h *frontierHeap ) Swap( i, j int ) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func ( h *frontierHeap ) Push( x interface{} ) { h.items = append( h.items, x.(frontierItem) ) }
+
+func ( h *frontierHeap ) Pop() interface{} {
+    n := len(h.items)
+    item := h.items[n-1]
+    h.items[n-1] = frontierItem{}
+    h.items = h.items[:n-1]
+    return item
+}
@@ -0,0 +1,297 @@
+package petitcrawler
+
+
+import (
+    "encoding/xml"
+    "fmt"
+    "io"
+    "net/url"
+    "os"
+    "strings"
+)
+
+
+// Graph is the link graph of a crawl: a node per page (or per path prefix, when collapsed) and an edge
+// per pair of nodes linked, counting the links
+type Graph struct {
+    Nodes []GraphNode
+    Edges []GraphEdge
+}
+
+
+// GraphNode is a page of a Graph, or the pages under a path prefix
+type GraphNode struct {
+
+    ID string           // the page's URL, or the path prefix
+    Status int          // http status, 0 if the URL wasn't fetched. For a prefix, of its first page
+    Depth int           // links from the start URL. For a prefix, the smallest of its pages. Without pages, one more than its closest linking page
+    Title string        // the page's <title>. For a prefix, of the page at the prefix if it was collected
+    Pages int           // number of collected pages in the node
+    Failed bool         // the URL is one of the crawler's Failed fetches, ex: no response
+
+}
+
+
+// GraphEdge is the links from one node to another
+type GraphEdge struct {
+    From string
+    To string
+    Count int           // number of links, counting each page's links once per target
+}
+
+
+// Graph builds the link graph of the collected pages, with their links to other pages and to URLs
+// that failed or weren't HTML. With collapse > 0, pages are grouped by their first collapse path segments,
+// ex: with 1, /docs/a and /docs/b/c are both /docs, and links inside a group are left out.
+func ( crawler *SingleCrawler ) Graph( collapse int ) (*Graph, error) {
+
+    graph := &Graph{}
+    nodes := make( map[string]int )
+    edges := make( map[[2]string]int )
+    // Pages and links are matched by their normalized URL
+    key := func( link string ) string {
+        if normalized, err := crawler.Normalizer.Normalize( link ); err == nil {
+            link = normalized
+        }
+        if collapse <= 0 {
+            return link
+        }
+        return collapsePath( link, collapse )
+    }
+    node := func( id string ) *GraphNode {
+        n, ok := nodes[id]
+        if ok == false {
+            n = len(graph.Nodes)
+            nodes[id] = n
+            graph.Nodes = append( graph.Nodes, GraphNode{ ID: id, Depth: -1 } )
+        }
+        return &graph.Nodes[n]
+    }
+
+    // Pages first, so they're the nodes in crawl order
+    var pages []Page
+    err := crawler.Storage.Pages( func( p Page ) error {
+        n := node( key(p.MyUrl) )
+        if n.Pages == 0 {
+            n.Status = p.Status
+        }
+        if n.Depth < 0 || p.Depth < n.Depth {
+            n.Depth = p.Depth
+        }
+        if n.Title == "" || strings.TrimSuffix( p.MyUrl, "/" ) == strings.TrimSuffix( n.ID, "/" ) {
+            n.Title = p.Title
+        }
+        n.Pages++
+        pages = append( pages, Page{ MyUrl: p.MyUrl, Depth: p.Depth, BabyUrls: p.BabyUrls } )
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    status := make( map[string]int )
+    failed := make( map[string]bool )
+    for i, f := range append( append( []Fetch{}, crawler.Failed... ), crawler.NonHTML... ) {
+        if target, err := crawler.Normalizer.Normalize( f.URL ); err == nil {
+            status[target] = f.Status
+            failed[target] = i < len(crawler.Failed)
+        }
+    }
+    for _, p := range pages {
+        from := key(p.MyUrl)
+        seen := make( map[string]bool )
+        for _, link := range p.BabyUrls {
+            target, err := crawler.Normalizer.Normalize( link )
+            if err != nil || seen[target] {
+                continue
+            }
+            seen[target] = true
+            to := key(target)
+            if collapse > 0 && to == from {
+                continue
+            }
+            if _, ok := nodes[to]; ok == false {
+                n := node( to )
+                n.Status = status[target]
+                n.Failed = failed[target]
+            }
+            // Nodes without pages are one link further than the closest page linking to them
+            if n := node( to ); n.Pages == 0 && ( n.Depth < 0 || p.Depth + 1 < n.Depth ) {
+                n.Depth = p.Depth + 1
+            }
+            e, ok := edges[[2]string{ from, to }]
+            if ok == false {
+                e = len(graph.Edges)
+                edges[[2]string{ from, to }] = e
+                graph.Edges = append( graph.Edges, GraphEdge{ From: from, To: to } )
+            }
+            graph.Edges[e].Count++
+        }
+    }
+    return graph, nil
+}
+
+
+// collapsePath cuts link down to its host and first n path segments, ex: http://a.com/docs for
+// http://a.com/docs/b/c?x=1 and n = 1
+func collapsePath( link string, n int ) string {
+
+    u, err := url.Parse( link )
+    if err != nil {
+        return link
+    }
+    segments := strings.Split( strings.Trim( u.Path, "/" ), "/" )
+    if len(segments) > n {
+        segments = segments[:n]
+    }
+    return u.Scheme + "://" + u.Host + "/" + strings.Join( segments, "/" )
+}
+
+
+// WriteDOT writes the graph in Graphviz DOT. Nodes are labelled with their title and URL, and the ones
+// with an error status or a failed fetch are red. Edges of more than one link are labelled with the count.
+func ( graph *Graph ) WriteDOT( w io.Writer ) error {
+
+    quote := func( s string ) string {
+        return `"` + strings.NewReplacer( `\`, `\\`, `"`, `\"`, "\n", `\n` ).Replace(s) + `"`
+    }
+    var b strings.Builder
+    b.WriteString( "digraph sitemap {\n  node [shape=box];\n" )
+    for _, n := range graph.Nodes {
+        label := n.ID
+        if n.Title != "" {
+            label = n.Title + "\n" + n.ID
+        }
+        fmt.Fprintf( &b, "  %s [label=%s, status=%d, depth=%d, pages=%d", quote(n.ID), quote(label), n.Status, n.Depth, n.Pages )
+        if n.Status >= 400 || n.Failed {
+            b.WriteString( ", color=red" )
+        }
+        b.WriteString( "];\n" )
+    }
+    for _, e := range graph.Edges {
+        fmt.Fprintf( &b, "  %s -> %s [weight=%d", quote(e.From), quote(e.To), e.Count )
+        if e.Count > 1 {
+            fmt.Fprintf( &b, ", label=\"%d\"", e.Count )
+        }
+        b.WriteString( "];\n" )
+    }
+    b.WriteString( "}\n" )
+    _, err := io.WriteString( w, b.String() )
+    return err
+}
+
+
+// GraphML document, see http://graphml.graphdrawing.org
+type graphML struct {
+    XMLName xml.Name        `xml:"graphml"`
+    Xmlns string            `xml:"xmlns,attr"`
+    Keys []graphMLKey       `xml:"key"`
+    Graph graphMLGraph      `xml:"graph"`
+}
+
+type graphMLKey struct {
+    ID string               `xml:"id,attr"`
+    For string              `xml:"for,attr"`
+    Name string             `xml:"attr.name,attr"`
+    Type string             `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+    ID string               `xml:"id,attr"`
+    EdgeDefault string      `xml:"edgedefault,attr"`
+    Nodes []graphMLNode     `xml:"node"`
+    Edges []graphMLEdge     `xml:"edge"`
+}
+
+type graphMLNode struct {
+    ID string               `xml:"id,attr"`
+    Data []graphMLData      `xml:"data"`
+}
+
+type graphMLEdge struct {
+    Source string           `xml:"source,attr"`
+    Target string           `xml:"target,attr"`
+    Data []graphMLData      `xml:"data"`
+}
+
+type graphMLData struct {
+    Key string              `xml:"key,attr"`
+    Value string            `xml:",chardata"`
+}
+
+
+// WriteGraphML writes the graph in GraphML. Nodes have url, title, status, depth and pages data, edges count.
+func ( graph *Graph ) WriteGraphML( w io.Writer ) error {
+
+    doc := graphML{ Xmlns: "http://graphml.graphdrawing.org/xmlns",
+        Keys: []graphMLKey{
+            { "url", "node", "url", "string" },
+            { "title", "node", "title", "string" },
+            { "status", "node", "status", "int" },
+            { "depth", "node", "depth", "int" },
+            { "pages", "node", "pages", "int" },
+            { "count", "edge", "count", "int" },
+        },
+        Graph: graphMLGraph{ ID: "sitemap", EdgeDefault: "directed" } }
+    ids := make( map[string]string )
+    for i, n := range graph.Nodes {
+        ids[n.ID] = fmt.Sprintf( "n%d", i )
+        doc.Graph.Nodes = append( doc.Graph.Nodes, graphMLNode{ ID: ids[n.ID], Data: []graphMLData{
+            { "url", n.ID }, { "title", n.Title }, { "status", fmt.Sprint(n.Status) },
+            { "depth", fmt.Sprint(n.Depth) }, { "pages", fmt.Sprint(n.Pages) } } } )
+    }
+    for _, e := range graph.Edges {
+        doc.Graph.Edges = append( doc.Graph.Edges, graphMLEdge{ Source: ids[e.From], Target: ids[e.To],
+            Data: []graphMLData{ { "count", fmt.Sprint(e.Count) } } } )
+    }
+
+    if _, err := io.WriteString( w, xml.Header ); err != nil {
+        return err
+    }
+    enc := xml.NewEncoder( w )
+    enc.Indent( "", "  " )
+    if err := enc.Encode( doc ); err != nil {
+        return err
+    }
+    _, err := io.WriteString( w, "\n" )
+    return err
+}
+
+
+// GraphOutput writes the link graph of the crawl to the crawler's Filename, in DOT or GraphML,
+// collapsing pages by the crawler's GraphCollapse path segments
+type GraphOutput struct {
+    GraphML bool        // GraphML instead of DOT
+}
+
+func ( out *GraphOutput ) Extension() string {
+    if out.GraphML {
+        return ".graphml"
+    }
+    return ".dot"
+}
+
+func ( out *GraphOutput ) Begin( crawler *SingleCrawler ) error { return nil }
+func ( out *GraphOutput ) Page( crawler *SingleCrawler, page Page ) error { return nil }
+
+
+func ( out *GraphOutput ) End( crawler *SingleCrawler ) error {
+
+    graph, err := crawler.Graph( crawler.GraphCollapse )
+    if err != nil {
+        return err
+    }
+    file, err := os.Create( crawler.Filename )
+    if err != nil {
+        return err
+    }
+    if out.GraphML {
+        err = graph.WriteGraphML( file )
+    } else {
+        err = graph.WriteDOT( file )
+    }
+    if cerr := file.Close(); err == nil {
+        err = cerr
+    }
+    return err
+}
@@ -0,0 +1,83 @@
+package petitcrawler
+
+
+import (
+    "crypto/tls"
+    "errors"
+    "net/http"
+    "time"
+)
+
+
+// Default timeout for a single http request made by a worker
+var DEFAULT_TIMEOUT = 10 * time.Second
+
+
+// buildClient sets up the http.Client the workers use to fetch pages.
+// A client passed in with WithHTTPClient is used as is (a copy gets the timeout if one was asked for).
+// Otherwise the client uses the custom RoundTripper from WithTransport, or a transport
+// built from the TLS, proxy and idle connection options.
+func buildClient( crawler *SingleCrawler ) error {
+
+    customTransport := crawler.TLSConfig != nil || crawler.RootCAs != nil || crawler.MinTLSVersion != 0 ||
+        crawler.ProxyURL != nil || crawler.MaxIdleConns > 0
+
+    if crawler.Client != nil {
+        if crawler.Transport != nil || customTransport {
+            return errors.New("Transport, TLS, proxy and idle connection options can't be used with a custom http.Client.")
+        }
+        if crawler.Timeout > 0 {
+            client := *crawler.Client
+            client.Timeout = crawler.Timeout
+            crawler.Client = &client
+        }
+        return nil
+    }
+
+    timeout := crawler.Timeout
+    if timeout == 0 {
+        timeout = DEFAULT_TIMEOUT
+    }
+
+    if crawler.Transport != nil {
+        if customTransport {
+            return errors.New("TLS, proxy and idle connection options can't be used with a custom Transport.")
+        }
+        crawler.Client = &http.Client{ Transport: crawler.Transport, Timeout: timeout }
+        return nil
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    if config := tlsConfig( crawler ); config != nil {
+        transport.TLSClientConfig = config
+    }
+    if crawler.ProxyURL != nil {
+        transport.Proxy = http.ProxyURL(crawler.ProxyURL)
+    }
+    if crawler.MaxIdleConns > 0 {
+        transport.MaxIdleConns = crawler.MaxIdleConns
+        transport.MaxIdleConnsPerHost = crawler.MaxIdleConns
+    }
+    crawler.Client = &http.Client{ Transport: transport, Timeout: timeout }
+    return nil
+}
+
+
+// tlsConfig merges the TLS options into a copy of the crawler's TLS config.
+// Returns nil if no TLS option was given.
+func tlsConfig( crawler *SingleCrawler ) *tls.Config {
+    if crawler.TLSConfig == nil && crawler.RootCAs == nil && crawler.MinTLSVersion == 0 {
+        return nil
+    }
+    config := &tls.Config{}
+    if crawler.TLSConfig != nil {
+        config = crawler.TLSConfig.Clone()
+    }
+    if crawler.RootCAs != nil {
+        config.RootCAs = crawler.RootCAs
+    }
+    if crawler.MinTLSVersion != 0 {
+        config.MinVersion = crawler.MinTLSVersion
+    }
+    return config
+}
@@ -0,0 +1,309 @@
+package petitcrawler
+
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+    "time"
+)
+
+
+// Schema name and version of the JSON and JSON Lines outputs. The version is bumped when a field
+// is removed or changes meaning, not when one is added.
+const JSON_SCHEMA = "petitcrawler.sitemap"
+const JSON_SCHEMA_VERSION = 1
+
+
+// JSONPage is a Page in the JSON outputs
+type JSONPage struct {
+
+    URL string                      `json:"url"`
+    FinalURL string                 `json:"final_url,omitempty"`
+    Redirects []Redirect            `json:"redirects,omitempty"`
+    Status int                      `json:"status"`
+    ContentType string              `json:"content_type,omitempty"`
+    ContentLength int64             `json:"content_length"`
+    ResponseTimeMs int64            `json:"response_time_ms"`
+    FetchedAt time.Time             `json:"fetched_at"`
+    Depth int                       `json:"depth"`
+    Parent string                   `json:"parent,omitempty"`
+    Title string                    `json:"title,omitempty"`
+    Description string              `json:"description,omitempty"`
+    Canonical string                `json:"canonical,omitempty"`
+    Lang string                     `json:"lang,omitempty"`
+    Headers map[string]string       `json:"headers,omitempty"`
+    Links []string                  `json:"links"`
+    Assets []string                 `json:"assets"`
+    Aliases []string                `json:"aliases,omitempty"`
+    Attempts int                    `json:"attempts"`
+    BodyHash string                 `json:"body_hash,omitempty"`
+    TextHash string                 `json:"text_hash,omitempty"`
+    SimHash string                  `json:"simhash,omitempty"`     // hex, a JSON number can't hold 64 bits
+
+}
+
+
+// NewJSONPage converts page to its JSON form
+func NewJSONPage( page Page ) JSONPage {
+
+    jp := JSONPage{ URL: page.MyUrl, FinalURL: page.FinalURL, Redirects: page.Redirects, Status: page.Status, ContentType: page.ContentType,
+        ContentLength: page.ContentLength, ResponseTimeMs: page.ResponseTime.Milliseconds(), FetchedAt: page.FetchedAt,
+        Depth: page.Depth, Parent: page.Parent, Title: page.Title, Description: page.Description, Canonical: page.Canonical,
+        Lang: page.Lang, Headers: page.Headers, Links: page.BabyUrls, Assets: page.Assets, Aliases: page.Aliases,
+        Attempts: len(page.Attempts), BodyHash: page.BodyHash, TextHash: page.TextHash }
+    if page.TextHash != "" {
+        jp.SimHash = fmt.Sprintf( "%016x", page.SimHash )
+    }
+    if jp.Links == nil {
+        jp.Links = []string{}
+    }
+    if jp.Assets == nil {
+        jp.Assets = []string{}
+    }
+    return jp
+}
+
+
+// JSONFetch is a failed or non-HTML URL in the JSON outputs
+type JSONFetch struct {
+
+    URL string                      `json:"url"`
+    Referrers []string              `json:"referrers,omitempty"`
+    Status int                      `json:"status"`
+    Error string                    `json:"error,omitempty"`
+    Transient bool                  `json:"transient,omitempty"`
+    Attempts int                    `json:"attempts"`
+    ContentType string              `json:"content_type,omitempty"`
+
+}
+
+
+// NewJSONFetch converts f to its JSON form
+func NewJSONFetch( f Fetch ) JSONFetch {
+    return JSONFetch{ URL: f.URL, Referrers: f.Referrers, Status: f.Status, Error: f.Err, Transient: f.Transient,
+        Attempts: len(f.Attempts), ContentType: f.ContentType }
+}
+
+
+// JSONExcluded is a URL that wasn't crawled in the JSON outputs
+type JSONExcluded struct {
+    URL string                      `json:"url"`
+    Reason string                   `json:"reason"`
+}
+
+
+// JSONSitemap is the document written by JSONOutput
+type JSONSitemap struct {
+
+    Schema string                   `json:"schema"`
+    Version int                     `json:"version"`
+    Site string                     `json:"site"`
+    Started time.Time               `json:"started"`
+    Finished time.Time              `json:"finished"`
+    Reason StopReason               `json:"reason"`
+    Pages []JSONPage                `json:"pages"`
+    Excluded []JSONExcluded         `json:"excluded"`
+    Failed []JSONFetch              `json:"failed"`
+    NonHTML []JSONFetch             `json:"non_html"`
+    NearDuplicates [][]string       `json:"near_duplicates"`
+
+}
+
+
+// JSONOutput writes the sitemap as one JSON document (a JSONSitemap) when the crawl stops.
+// It is built in memory, JSONLinesOutput streams very large crawls instead.
+type JSONOutput struct {
+    started time.Time
+}
+
+func ( out *JSONOutput ) Extension() string { return ".json" }
+func ( out *JSONOutput ) Page( crawler *SingleCrawler, page Page ) error { return nil }
+
+func ( out *JSONOutput ) Begin( crawler *SingleCrawler ) error {
+    out.started = time.Now()
+    return nil
+}
+
+
+func ( out *JSONOutput ) End( crawler *SingleCrawler ) error {
+
+    doc := JSONSitemap{ Schema: JSON_SCHEMA, Version: JSON_SCHEMA_VERSION, Site: crawler.Site.String(),
+        Started: out.started, Finished: time.Now(), Reason: crawler.Reason, Pages: []JSONPage{},
+        Excluded: jsonExcluded( crawler ), Failed: jsonFetches( crawler.Failed ), NonHTML: jsonFetches( crawler.NonHTML ) }
+    err := crawler.EachPage( func( p Page ) error {
+        doc.Pages = append( doc.Pages, NewJSONPage(p) )
+        return nil
+    })
+    if err == nil {
+        doc.NearDuplicates, err = crawler.NearDuplicates()
+    }
+    if err != nil {
+        return err
+    }
+    if doc.NearDuplicates == nil {
+        doc.NearDuplicates = [][]string{}
+    }
+
+    file, err := os.Create( crawler.Filename )
+    if err != nil {
+        return err
+    }
+    enc := json.NewEncoder( file )
+    enc.SetIndent( "", "  " )
+    if err = enc.Encode( doc ); err != nil {
+        file.Close()
+        return err
+    }
+    return file.Close()
+}
+
+
+// JSONLinesOutput writes the sitemap as JSON Lines, one record per line, each with a "type".
+// The first line is the "crawl" record, then a "page" record (a JSONPage) is written as each page is
+// collected. When the crawl stops "alias", "excluded", "failed", "non_html" and "near_duplicates"
+// records follow, and an "end" record is the last line.
+type JSONLinesOutput struct {
+    file *os.File
+    w *bufio.Writer
+    enc *json.Encoder
+}
+
+func ( out *JSONLinesOutput ) Extension() string { return ".jsonl" }
+
+
+// Begin creates the file and writes the crawl record, and the pages a resumed crawl already collected
+func ( out *JSONLinesOutput ) Begin( crawler *SingleCrawler ) error {
+
+    file, err := os.Create( crawler.Filename )
+    if err != nil {
+        return err
+    }
+    out.file = file
+    out.w = bufio.NewWriter( file )
+    out.enc = json.NewEncoder( out.w )
+
+    err = out.enc.Encode( struct{
+        Type string         `json:"type"`
+        Schema string       `json:"schema"`
+        Version int         `json:"version"`
+        Site string         `json:"site"`
+        Started time.Time   `json:"started"`
+    }{ "crawl", JSON_SCHEMA, JSON_SCHEMA_VERSION, crawler.Site.String(), time.Now() })
+    if err == nil {
+        err = crawler.Storage.Pages( func( p Page ) error {
+            return out.Page( crawler, p )
+        })
+    }
+    if err != nil {
+        out.file.Close()
+        out.file = nil
+    }
+    return err
+}
+
+
+// Page writes a page record, and flushes it so readers of the file see pages as they're collected
+func ( out *JSONLinesOutput ) Page( crawler *SingleCrawler, page Page ) error {
+
+    if out.file == nil {
+        return nil
+    }
+    err := out.enc.Encode( struct{
+        Type string         `json:"type"`
+        JSONPage
+    }{ "page", NewJSONPage(page) })
+    if err != nil {
+        return err
+    }
+    return out.w.Flush()
+}
+
+
+func ( out *JSONLinesOutput ) End( crawler *SingleCrawler ) error {
+
+    if out.file == nil {
+        return nil
+    }
+    defer func() {
+        out.file.Close()
+        out.file = nil
+    }()
+
+    type record struct {
+        Type string         `json:"type"`
+        URL string          `json:"url,omitempty"`
+        AliasOf string      `json:"alias_of,omitempty"`
+        Reason string       `json:"reason,omitempty"`
+        URLs []string       `json:"urls,omitempty"`
+    }
+    err := crawler.EachPage( func( p Page ) error {
+        for _, alias := range p.Aliases {
+            if err := out.enc.Encode( record{ Type: "alias", URL: alias, AliasOf: p.MyUrl } ); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    for _, e := range jsonExcluded( crawler ) {
+        if err == nil {
+            err = out.enc.Encode( record{ Type: "excluded", URL: e.URL, Reason: e.Reason } )
+        }
+    }
+    for i, fetches := range [][]Fetch{ crawler.Failed, crawler.NonHTML } {
+        for _, f := range fetches {
+            if err == nil {
+                err = out.enc.Encode( struct{
+                    Type string         `json:"type"`
+                    JSONFetch
+                }{ []string{ "failed", "non_html" }[i], NewJSONFetch(f) })
+            }
+        }
+    }
+    if err == nil {
+        var clusters [][]string
+        clusters, err = crawler.NearDuplicates()
+        for _, cluster := range clusters {
+            if err == nil {
+                err = out.enc.Encode( record{ Type: "near_duplicates", URLs: cluster } )
+            }
+        }
+    }
+    if err == nil {
+        err = out.enc.Encode( struct{
+            Type string         `json:"type"`
+            Reason StopReason   `json:"reason"`
+            Pages int           `json:"pages"`
+            Finished time.Time  `json:"finished"`
+        }{ "end", crawler.Reason, crawler.NumPages, time.Now() })
+    }
+    if err == nil {
+        err = out.w.Flush()
+    }
+    return err
+}
+
+
+// jsonExcluded lists the crawler's excluded URLs, sorted
+func jsonExcluded( crawler *SingleCrawler ) []JSONExcluded {
+
+    excluded := make( []JSONExcluded, 0, len(crawler.Excluded) )
+    for link, reason := range crawler.Excluded {
+        excluded = append( excluded, JSONExcluded{ URL: link, Reason: reason } )
+    }
+    sort.Slice( excluded, func( i, j int ) bool { return excluded[i].URL < excluded[j].URL } )
+    return excluded
+}
+
+
+// jsonFetches converts fetches to their JSON form
+func jsonFetches( fetches []Fetch ) []JSONFetch {
+
+    out := make( []JSONFetch, 0, len(fetches) )
+    for _, f := range fetches {
+        out = append( out, NewJSONFetch(f) )
+    }
+    return out
+}
@@ -0,0 +1,21 @@
+package petitcrawler
+
+
+// Link is a URL on its way to being crawled, with where it was found
+type Link struct {
+
+    URL string
+    Depth int           // number of links followed from the start URL, which is at depth 0
+    Parent string       // URL of the page the link was found on, empty for the start URL
+
+}
+
+
+// Anchor is an <a href> found on a page, whether it's crawled or not
+type Anchor struct {
+
+    URL string          // the href, resolved against the page
+    Text string         // visible text of the link, whitespace collapsed
+    Internal bool       // the URL is on the site being crawled
+
+}
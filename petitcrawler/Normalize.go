@@ -0,0 +1,196 @@
+package petitcrawler
+
+
+import (
+    "errors"
+    "fmt"
+    "net/url"
+    "path"
+    "sort"
+    "strings"
+)
+
+
+// Query parameters that only track where a visitor came from, dropped by default
+var DEFAULT_TRACKING_PARAMS = []string{ "utm_*", "gclid", "fbclid", "msclkid", "mc_cid", "mc_eid", "_ga" }
+
+// Default rules URLs are normalized with before they are queued
+var DEFAULT_NORMALIZER = Normalizer{
+    StripFragment: true,
+    SortQuery: true,
+    TrackingParams: DEFAULT_TRACKING_PARAMS,
+    FoldTrailingSlash: false,
+    FoldIndex: false,
+    IndexNames: []string{ "index.html", "index.htm" },
+}
+
+
+// Normalizer turns the different ways of writing a URL into one canonical string,
+// so the same page isn't crawled twice. The scheme and host are always lowercased,
+// default ports removed, and percent-encoding normalized. The rest is configurable.
+type Normalizer struct {
+
+    StripFragment bool          // drop #fragments
+    SortQuery bool              // sort query parameters
+    TrackingParams []string     // query parameters to drop, a trailing '*' matches any suffix (ex: utm_*)
+    FoldTrailingSlash bool      // treat /a/ the same as /a
+    FoldIndex bool              // treat /a/index.html the same as /a/
+    IndexNames []string         // file names FoldIndex removes
+
+}
+
+
+// Normalize parses link and returns its normalized form
+func ( n *Normalizer ) Normalize( link string ) (string, error) {
+
+    u, err := url.Parse( strings.TrimSpace(link) )
+    if err != nil {
+        return "", err
+    }
+    if u.IsAbs() == false || u.Host == "" {
+        return "", errors.New( fmt.Sprintf("Can't normalize relative URL %s.", link))
+    }
+    return n.NormalizeURL( u ), nil
+}
+
+
+// NormalizeURL returns the normalized form of the absolute URL u
+func ( n *Normalizer ) NormalizeURL( u *url.URL ) string {
+
+    scheme := strings.ToLower( u.Scheme )
+    host := strings.ToLower( u.Host )
+    if (scheme == "http" && strings.HasSuffix(host, ":80")) || (scheme == "https" && strings.HasSuffix(host, ":443")) {
+        host = host[:strings.LastIndex(host, ":")]
+    }
+
+    p := normalizeEscapes( u.EscapedPath() )
+    if p == "" {
+        p = "/"
+    }
+    if n.FoldIndex {
+        for _, name := range n.IndexNames {
+            if path.Base(p) == name {
+                p = strings.TrimSuffix( p, name )
+                break
+            }
+        }
+    }
+    if n.FoldTrailingSlash && len(p) > 1 {
+        p = strings.TrimRight( p, "/" )
+        if p == "" {
+            p = "/"
+        }
+    }
+
+    var b strings.Builder
+    b.WriteString( scheme )
+    b.WriteString( "://" )
+    if u.User != nil {
+        b.WriteString( u.User.String() )
+        b.WriteString( "@" )
+    }
+    b.WriteString( host )
+    b.WriteString( p )
+    if query := n.normalizeQuery( u.RawQuery ); query != "" {
+        b.WriteString( "?" )
+        b.WriteString( query )
+    }
+    if n.StripFragment == false && u.Fragment != "" {
+        b.WriteString( "#" )
+        b.WriteString( u.EscapedFragment() )
+    }
+    return b.String()
+}
+
+
+// normalizeQuery drops tracking parameters from a raw query, and sorts it if asked to
+func ( n *Normalizer ) normalizeQuery( rawQuery string ) string {
+
+    if rawQuery == "" {
+        return ""
+    }
+    var params []string
+    for _, param := range strings.Split( rawQuery, "&" ) {
+        if param == "" {
+            continue
+        }
+        key := param
+        if i := strings.Index(param, "="); i >= 0 {
+            key = param[:i]
+        }
+        if k, err := url.QueryUnescape(key); err == nil {
+            key = k
+        }
+        if n.isTracking(key) {
+            continue
+        }
+        params = append( params, normalizeEscapes(param) )
+    }
+    if n.SortQuery {
+        sort.Strings(params)
+    }
+    return strings.Join( params, "&" )
+}
+
+
+// isTracking reports if a query parameter is in the tracking list
+func ( n *Normalizer ) isTracking( key string ) bool {
+
+    key = strings.ToLower(key)
+    for _, t := range n.TrackingParams {
+        t = strings.ToLower(t)
+        if strings.HasSuffix(t, "*") && strings.HasPrefix(key, t[:len(t)-1]) {
+            return true
+        }
+        if key == t {
+            return true
+        }
+    }
+    return false
+}
+
+
+// normalizeEscapes decodes percent-encoded unreserved characters (letters, digits, - . _ ~)
+// and uppercases the hex digits of the escapes that are left, per RFC 3986
+func normalizeEscapes( s string ) string {
+
+    if strings.Contains(s, "%") == false {
+        return s
+    }
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+            c := unhex(s[i+1])<<4 | unhex(s[i+2])
+            if isUnreserved(c) {
+                b.WriteByte(c)
+            } else {
+                b.WriteString( strings.ToUpper(s[i:i+3]) )
+            }
+            i += 2
+            continue
+        }
+        b.WriteByte(s[i])
+    }
+    return b.String()
+}
+
+
+func isHex( c byte ) bool {
+    return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+
+func unhex( c byte ) byte {
+    switch {
+        case '0' <= c && c <= '9':
+            return c - '0'
+        case 'a' <= c && c <= 'f':
+            return c - 'a' + 10
+    }
+    return c - 'A' + 10
+}
+
+
+func isUnreserved( c byte ) bool {
+    return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,482 @@
+package petitcrawler
+
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "time"
+    "github.com/golang/glog"
+)
+
+
+// Default values used by NewSingleCrawler when no Option overrides them
+var DEFAULT_PRINT_LIMIT = 10
+var DEFAULT_MAX_PAGES = 500
+var DEFAULT_MAX_TIME = 3 * time.Minute
+var DEFAULT_MAX_DEPTH = -1              // no limit
+var DEFAULT_NUM_WORKERS = 100
+var DEFAULT_NEAR_DUP_DISTANCE = 6       // bits of SimHash
+var DEFAULT_USER_AGENT = "petitcrawler/1.0"
+var DEFAULT_PAGE_HEADERS = []string{ "Last-Modified", "ETag", "Cache-Control", "Content-Language", "Server", "X-Robots-Tag" }
+
+
+// An Option configures a SingleCrawler while it is being created by NewSingleCrawler.
+// Options return an error when given a value the crawler can't work with.
+type Option func( crawler *SingleCrawler ) error
+
+
+// WithPrintLimit sets how many assets/children are printed per Page in the sitemap
+func WithPrintLimit( n int ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if n < 0 {
+            return errors.New("Print limit must be >= 0.")
+        }
+        crawler.PRINT_LIMIT = n
+        return nil
+    }
+}
+
+
+// WithMaxPages sets the maximum number of pages to collect
+func WithMaxPages( n int ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if n < 0 {
+            return errors.New("Max pages must be >= 0.")
+        }
+        crawler.MAX_PAGES = n
+        return nil
+    }
+}
+
+
+// WithMaxDepth sets how many links away from the start URL to crawl, 0 is only the start URL.
+// A negative depth means no limit.
+func WithMaxDepth( n int ) Option {
+    return func( crawler *SingleCrawler ) error {
+        crawler.MaxDepth = n
+        return nil
+    }
+}
+
+
+// WithMaxTime sets the maximum amount of time to crawl for
+func WithMaxTime( d time.Duration ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if d < 0 {
+            return errors.New("Max time must be >= 0.")
+        }
+        crawler.MAX_TIME = d
+        return nil
+    }
+}
+
+
+// WithNumWorkers sets the number of worker goroutines to spawn, must be in (0, MAX_WORKERS]
+func WithNumWorkers( n int ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if n <= 0 || n > MAX_WORKERS {
+            return errors.New("Bad value for NumWorkers, must be > 0 and <= MAX_WORKERS.")
+        }
+        crawler.NumWorkers = n
+        return nil
+    }
+}
+
+
+// WithFilename sets the file the sitemap is written to.
+// An empty name keeps the default of <domain name>.txt
+func WithFilename( name string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if len(name) >= 255 {
+            glog.Error("Filename can't be larger than 255 characters. Trimming Filename.")
+            name = name[0:100]
+        }
+        crawler.Filename = name
+        return nil
+    }
+}
+
+
+// WithStorage keeps the crawl's frontier, visited URLs and pages in store, instead of in memory.
+// The store's frontier decides the crawl order, Strategy and Score are not used.
+// Can't be used with a checkpoint state file, which is the crawl's Storage.
+func WithStorage( store Storage ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if store == nil {
+            return errors.New("Storage can't be nil.")
+        }
+        crawler.Storage = store
+        return nil
+    }
+}
+
+
+// WithStorageDir keeps the crawl's frontier, visited URLs and pages on disk,
+// in a <domain name>.db file in dir, for sites too large to crawl in memory.
+// The crawler must be closed when done with, to sync the file.
+func WithStorageDir( dir string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if info, err := os.Stat(dir); err != nil || info.IsDir() == false {
+            return errors.New( fmt.Sprintf("Storage directory %s doesn't exist.", dir))
+        }
+        if crawler.CheckpointFile != "" {
+            return errors.New("Can't use both a storage directory and a checkpoint state file.")
+        }
+        crawler.StorageDir = dir
+        return nil
+    }
+}
+
+
+// WithCheckpoint keeps the crawl's frontier, visited URLs and pages in the state file at path,
+// and checkpoints the rest of the crawl's state to it every interval (and when it stops),
+// so the crawl can be continued with Resume if it is interrupted.
+func WithCheckpoint( path string, every time.Duration ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if path == "" {
+            return errors.New("Checkpoint state file can't be empty.")
+        }
+        if every <= 0 {
+            return errors.New("Checkpoint interval must be > 0.")
+        }
+        if crawler.StorageDir != "" {
+            return errors.New("Can't use both a storage directory and a checkpoint state file.")
+        }
+        crawler.CheckpointFile = path
+        crawler.CheckpointEvery = every
+        return nil
+    }
+}
+
+
+// WithHTTPClient makes the workers use the given client for every request.
+// Can't be combined with the transport, TLS, proxy or idle connection options.
+func WithHTTPClient( client *http.Client ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if client == nil {
+            return errors.New("http.Client can't be nil.")
+        }
+        crawler.Client = client
+        return nil
+    }
+}
+
+
+// WithTransport makes the workers send requests through the given RoundTripper
+func WithTransport( transport http.RoundTripper ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if transport == nil {
+            return errors.New("Transport can't be nil.")
+        }
+        crawler.Transport = transport
+        return nil
+    }
+}
+
+
+// WithTimeout sets the timeout for a single request (default DEFAULT_TIMEOUT)
+func WithTimeout( d time.Duration ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if d <= 0 {
+            return errors.New("Request timeout must be > 0.")
+        }
+        crawler.Timeout = d
+        return nil
+    }
+}
+
+
+// WithTLSConfig sets the TLS config used to connect to https sites.
+// The config is copied, so it can be combined with WithRootCAs and WithMinTLSVersion in any order.
+func WithTLSConfig( config *tls.Config ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if config == nil {
+            return errors.New("TLS config can't be nil.")
+        }
+        crawler.TLSConfig = config.Clone()
+        return nil
+    }
+}
+
+
+// WithRootCAs trusts the PEM encoded certificates in caFile, on top of the system roots.
+// Useful for crawling sites behind an internal CA.
+func WithRootCAs( caFile string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        pem, err := os.ReadFile( caFile )
+        if err != nil {
+            return errors.New( fmt.Sprintf("Unable to read CA bundle %s. Error is %s.", caFile, err))
+        }
+        pool, err := x509.SystemCertPool()
+        if err != nil {
+            pool = x509.NewCertPool()
+        }
+        if pool.AppendCertsFromPEM( pem ) == false {
+            return errors.New( fmt.Sprintf("No certificates found in CA bundle %s.", caFile))
+        }
+        crawler.RootCAs = pool
+        return nil
+    }
+}
+
+
+// WithMinTLSVersion sets the minimum TLS version to accept, ex: tls.VersionTLS12
+func WithMinTLSVersion( version uint16 ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if version < tls.VersionTLS10 || version > tls.VersionTLS13 {
+            return errors.New("Unknown TLS version.")
+        }
+        crawler.MinTLSVersion = version
+        return nil
+    }
+}
+
+
+// WithProxy sends all requests through the proxy at proxyURL
+func WithProxy( proxyURL string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        u, err := url.Parse( proxyURL )
+        if err != nil || u.Host == "" {
+            return errors.New( fmt.Sprintf("Bad proxy URL %s.", proxyURL))
+        }
+        crawler.ProxyURL = u
+        return nil
+    }
+}
+
+
+// WithMaxIdleConns sets the maximum number of idle (keep-alive) connections kept open
+func WithMaxIdleConns( n int ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if n <= 0 {
+            return errors.New("Max idle connections must be > 0.")
+        }
+        crawler.MaxIdleConns = n
+        return nil
+    }
+}
+
+
+// WithUserAgent sets the User-Agent sent with every request, which is also
+// the agent robots.txt rules are matched against
+func WithUserAgent( userAgent string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if userAgent == "" {
+            return errors.New("User agent can't be empty.")
+        }
+        crawler.UserAgent = userAgent
+        return nil
+    }
+}
+
+
+// WithPageHeaders sets which response headers are recorded in each Page's Headers (default DEFAULT_PAGE_HEADERS).
+// WriteSitemap takes <lastmod> from Last-Modified, so leaving it out leaves out <lastmod>.
+func WithPageHeaders( names ...string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        crawler.PageHeaders = append( []string{}, names... )
+        return nil
+    }
+}
+
+
+// WithIgnoreRobots skips fetching and obeying robots.txt.
+// Only use this for sites you own!
+func WithIgnoreRobots( ignore bool ) Option {
+    return func( crawler *SingleCrawler ) error {
+        crawler.IgnoreRobots = ignore
+        return nil
+    }
+}
+
+
+// WithRateLimit limits requests to each host to rate per second, shared by all workers,
+// allowing bursts of up to burst requests. A rate of 0 turns the limit off.
+func WithRateLimit( rate float64, burst int ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if rate < 0 || burst < 0 {
+            return errors.New("Rate limit and burst must be >= 0.")
+        }
+        crawler.RateLimit = rate
+        crawler.Burst = burst
+        return nil
+    }
+}
+
+
+// WithMinDelay keeps at least d between any two requests to the same host.
+// A longer Crawl-delay in the host's robots.txt takes precedence.
+func WithMinDelay( d time.Duration ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if d < 0 {
+            return errors.New("Minimum delay must be >= 0.")
+        }
+        crawler.MinDelay = d
+        return nil
+    }
+}
+
+
+// WithRetryPolicy sets which failed requests are retried, how many times, and the waits in between
+func WithRetryPolicy( policy RetryPolicy ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if policy.MaxAttempts < 1 {
+            return errors.New("Retry policy needs at least 1 attempt.")
+        }
+        if policy.BaseDelay < 0 || policy.MaxDelay < 0 || policy.Jitter < 0 || policy.Jitter > 1 {
+            return errors.New("Retry delays must be >= 0, and jitter between 0 and 1.")
+        }
+        crawler.Retry = &policy
+        return nil
+    }
+}
+
+
+// WithNormalizer sets the rules URLs are normalized with before they are checked
+// against the visited list and queued (default DEFAULT_NORMALIZER)
+func WithNormalizer( normalizer Normalizer ) Option {
+    return func( crawler *SingleCrawler ) error {
+        crawler.Normalizer = &normalizer
+        return nil
+    }
+}
+
+
+// WithScope sets which hosts are crawled (default ScopeWWW).
+// hosts is the allow list for ScopeAllowList, the start URL's host is always allowed.
+func WithScope( mode ScopeMode, hosts ...string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if mode < ScopeExactHost || mode > ScopeAllowList {
+            return errors.New("Unknown scope mode.")
+        }
+        crawler.ScopeMode = mode
+        crawler.AllowedHosts = hosts
+        return nil
+    }
+}
+
+
+// WithInclude only crawls URLs matching at least one of the rules, written as for ParseRule
+// (ex: "/docs/", "glob:/blog/*/2017/*", "regexp:lang=en")
+func WithInclude( rules ...string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        for _, r := range rules {
+            rule, err := ParseRule( r )
+            if err != nil {
+                return err
+            }
+            crawler.IncludeRules = append( crawler.IncludeRules, rule )
+        }
+        return nil
+    }
+}
+
+
+// WithExclude never crawls URLs matching any of the rules, written as for ParseRule
+// (ex: "/search", "glob:/calendar/*", "regexp:[?&]sessionid=")
+func WithExclude( rules ...string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        for _, r := range rules {
+            rule, err := ParseRule( r )
+            if err != nil {
+                return err
+            }
+            crawler.ExcludeRules = append( crawler.ExcludeRules, rule )
+        }
+        return nil
+    }
+}
+
+
+// WithStrategy sets the order links are crawled in (default BreadthFirst)
+func WithStrategy( strategy Strategy ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if strategy < BreadthFirst || strategy > BestFirst {
+            return errors.New("Unknown crawl strategy.")
+        }
+        crawler.Strategy = strategy
+        return nil
+    }
+}
+
+
+// WithFormat sets the format the sitemap is written in, one of Formats (default "text")
+func WithFormat( name string ) Option {
+    return func( crawler *SingleCrawler ) error {
+        output, err := NewOutput( name )
+        if err != nil {
+            return err
+        }
+        crawler.Output = output
+        return nil
+    }
+}
+
+
+// WithOutput writes the sitemap with your own Output
+func WithOutput( output Output ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if output == nil {
+            return errors.New("Output can't be nil.")
+        }
+        crawler.Output = output
+        return nil
+    }
+}
+
+
+// WithGraphCollapse groups pages by their first n path segments in the "dot" and "graphml" link graphs,
+// for large sites. 0 (the default) keeps a node per page.
+func WithGraphCollapse( n int ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if n < 0 {
+            return errors.New("Graph collapse must be >= 0.")
+        }
+        crawler.GraphCollapse = n
+        return nil
+    }
+}
+
+
+// WithDedup sets what makes two pages duplicates (default DedupURL). Only the first of them is kept,
+// the others are listed as its Aliases.
+func WithDedup( mode DedupMode ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if mode < DedupURL || mode > DedupText {
+            return errors.New("Unknown dedup mode.")
+        }
+        crawler.Dedup = mode
+        return nil
+    }
+}
+
+
+// WithNearDupDistance sets how many bits the SimHash fingerprints of two pages can differ by
+// for them to be reported as near duplicates, 0 to 63. A negative distance turns the report off.
+func WithNearDupDistance( n int ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if n > 63 {
+            return errors.New("Near duplicate distance must be < 64.")
+        }
+        crawler.NearDupDistance = n
+        return nil
+    }
+}
+
+
+// WithScore crawls best-first, highest scoring links first
+func WithScore( score ScoreFunc ) Option {
+    return func( crawler *SingleCrawler ) error {
+        if score == nil {
+            return errors.New("Score function can't be nil.")
+        }
+        crawler.Strategy = BestFirst
+        crawler.Score = score
+        return nil
+    }
+}
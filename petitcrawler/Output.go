@@ -0,0 +1,63 @@
+package petitcrawler
+
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+
+// Output writes the results of a crawl to the crawler's Filename, in one format.
+// RunContext calls Begin before crawling, Page for each page as it is collected, and End once the crawl stopped.
+// All three are called from the controller loop, never at the same time.
+type Output interface {
+
+    Extension() string                                  // file extension of the default Filename, ex: ".txt"
+    Begin( crawler *SingleCrawler ) error               // the crawl is starting, pages from a resumed crawl are in its Storage
+    Page( crawler *SingleCrawler, page Page ) error     // a page was collected
+    End( crawler *SingleCrawler ) error                 // the crawl stopped, its Excluded and Failed URLs are final
+
+}
+
+
+// Formats are the Outputs that can be picked by name with WithFormat. Add to it to plug in your own.
+var Formats = map[string]func() Output{
+    "text": func() Output { return &TextOutput{} },
+    "json": func() Output { return &JSONOutput{} },
+    "jsonl": func() Output { return &JSONLinesOutput{} },
+    "csv": func() Output { return &CSVOutput{ Comma: ',' } },
+    "tsv": func() Output { return &CSVOutput{ Comma: '\t' } },
+    "dot": func() Output { return &GraphOutput{} },
+    "graphml": func() Output { return &GraphOutput{ GraphML: true } },
+    "report": func() Output { return &ReportOutput{} },
+}
+
+
+// NewOutput creates the Output for the format called name
+func NewOutput( name string ) (Output, error) {
+
+    newOutput, ok := Formats[strings.ToLower(name)]
+    if ok == false {
+        names := make( []string, 0, len(Formats) )
+        for format := range Formats {
+            names = append( names, format )
+        }
+        sort.Strings( names )
+        return nil, errors.New( fmt.Sprintf("Unknown output format %s, must be one of %s.", name, strings.Join(names, ", ")))
+    }
+    return newOutput(), nil
+}
+
+
+// TextOutput writes the human readable sitemap of Print, the default Output
+type TextOutput struct{}
+
+func ( out *TextOutput ) Extension() string { return ".txt" }
+func ( out *TextOutput ) Begin( crawler *SingleCrawler ) error { return nil }
+func ( out *TextOutput ) Page( crawler *SingleCrawler, page Page ) error { return nil }
+
+func ( out *TextOutput ) End( crawler *SingleCrawler ) error {
+    return crawler.Print()
+}
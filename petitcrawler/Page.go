@@ -3,6 +3,8 @@ package petitcrawler
 
 import (
     "fmt"
+    "sort"
+    "time"
 )
 
 
@@ -11,17 +13,94 @@ import (
 type Page struct { 
 
     MyUrl string        // the URL of the Page
+    Depth int           // number of links followed from the start URL to get here
+    Parent string       // URL of the page this one was first found on, empty for the start URL
     Assets []string     // static Assets
+    AssetURLs []string  // the Assets resolved against the page's URL or <base href>, in the same order
     BabyUrls []string    // the URL of the Page this link was found on
+    Anchors []Anchor    // every http(s) <a href> on the Page, with its text, including external and rejected links
+    Status int          // HTTP status of the response
+    FinalURL string     // URL of the response, after redirects
+    Redirects []Redirect    // the redirects followed to get to FinalURL, starting from MyUrl
+    ContentType string
+    ContentLength int64 // size of the body read, in bytes
+    ResponseTime time.Duration  // from sending the request that succeeded to reading its body
+    FetchedAt time.Time
+    Headers map[string]string   // the Fetcher's selected response headers that were sent
+    Title string        // text of the <title>
+    Description string  // content of <meta name="description">
+    Canonical string    // href of <link rel="canonical">, resolved
+    Lang string         // lang of <html>
+    Attempts []Attempt  // the requests made to fetch the Page
+    Rejected map[string]string  // in-domain URLs found on the Page that an include/exclude rule rejected, and the rule
+    BodyHash string     // sha256 of the response body, hex
+    TextHash string     // sha256 of the visible text, hex
+    SimHash uint64      // fingerprint of the visible text, close for near-duplicate pages
+    Aliases []string    // URLs of pages that were duplicates of this one, filled in by SingleCrawler.EachPage
 
 }
 
 
+// Redirect is one hop of a redirect chain: a URL, and the status it redirected with
+type Redirect struct {
+    URL string          `json:"url"`
+    Status int          `json:"status"`
+}
+
+
 // Prints out the information a Page struct is storing
 // Only prints the first PRINT_LIMIT Assets and URLS
 func ( page *Page ) Print(PRINT_LIMIT int) {
 
-    fmt.Printf( "Page URL: %s\n\n", page.MyUrl )
+    fmt.Printf( "Page URL: %s\n", page.MyUrl )
+    if page.Parent != "" {
+        fmt.Printf( "Depth %d, found on %s\n\n", page.Depth, page.Parent )
+    } else {
+        fmt.Printf( "Depth %d\n\n", page.Depth )
+    }
+    if page.Status != 0 {
+        fmt.Printf( "Status %d, %s, %d bytes in %s, fetched %s\n", page.Status, page.ContentType, page.ContentLength,
+            page.ResponseTime.Round(time.Millisecond), page.FetchedAt.Format(time.RFC3339) )
+        if page.FinalURL != "" && page.FinalURL != page.MyUrl {
+            fmt.Printf( "Redirected to %s", page.FinalURL )
+            if len( page.Redirects ) > 1 {
+                fmt.Printf( " in %d hops", len(page.Redirects) )
+            }
+            fmt.Print( "\n" )
+        }
+        fmt.Print( "\n" )
+    }
+    if page.Title != "" {
+        fmt.Printf( "Title: %s\n", page.Title )
+    }
+    if page.Description != "" {
+        fmt.Printf( "Description: %s\n", page.Description )
+    }
+    if page.Canonical != "" {
+        fmt.Printf( "Canonical: %s\n", page.Canonical )
+    }
+    if page.Lang != "" {
+        fmt.Printf( "Language: %s\n", page.Lang )
+    }
+    if len( page.Headers ) > 0 {
+        names := make( []string, 0, len(page.Headers) )
+        for name := range page.Headers {
+            names = append( names, name )
+        }
+        sort.Strings( names )
+        for _, name := range names {
+            fmt.Printf( "%s: %s\n", name, page.Headers[name] )
+        }
+    }
+    if page.Title != "" || page.Description != "" || page.Canonical != "" || page.Lang != "" || len(page.Headers) > 0 {
+        fmt.Print( "\n" )
+    }
+    if len( page.Aliases ) > 0 {
+        fmt.Printf( "Duplicates (%d):\n\t%s\n\n", len(page.Aliases), page.Aliases )
+    }
+    if len( page.Attempts ) > 1 {
+        fmt.Printf( "Fetched after %d attempts\n\n", len(page.Attempts) )
+    }
     if len( page.Assets ) > PRINT_LIMIT {
         fmt.Printf( "Assets (%d):\n\t%s\n\n", len(page.Assets), page.Assets[0:PRINT_LIMIT] )
     } else { 
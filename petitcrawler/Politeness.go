@@ -0,0 +1,188 @@
+package petitcrawler
+
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+
+// Default politeness settings for each crawled host
+var DEFAULT_RATE_LIMIT = 10.0               // requests per second
+var DEFAULT_BURST = 5                       // requests allowed at once, before the rate kicks in
+var DEFAULT_BACKOFF = 10 * time.Second      // pause after a 429/503 without a Retry-After
+var MAX_BACKOFF = 10 * time.Minute          // longest pause a Retry-After can ask for
+
+
+// Limiter spaces out the requests all workers make to the crawled host.
+// It allows rate requests per second with bursts of up to burst requests,
+// keeps at least minDelay between any two requests, and can be paused by Backoff.
+// A rate of 0 means no rate limit.
+type Limiter struct {
+
+    mu sync.Mutex
+    interval time.Duration      // time between requests at the steady rate
+    tolerance time.Duration     // how far ahead of the steady rate a burst may get
+    minDelay time.Duration      // minimum time between two requests
+    next time.Time              // when the next request is due at the steady rate
+    last time.Time              // when the last request was let through
+    pausedUntil time.Time       // no requests before this time
+
+}
+
+
+// NewLimiter creates a Limiter for rate requests per second, with bursts of burst requests,
+// and at least minDelay between requests
+func NewLimiter( rate float64, burst int, minDelay time.Duration ) *Limiter {
+
+    limiter := &Limiter{ minDelay: minDelay }
+    if rate > 0 {
+        limiter.interval = time.Duration( float64(time.Second) / rate )
+        if burst > 1 {
+            limiter.tolerance = time.Duration(burst-1) * limiter.interval
+        }
+    }
+    return limiter
+}
+
+
+// reserve books the earliest slot a request can go out in, and returns it
+func ( limiter *Limiter ) reserve() time.Time {
+
+    limiter.mu.Lock()
+    defer limiter.mu.Unlock()
+
+    now := time.Now()
+    at := now
+    if t := limiter.next.Add(-limiter.tolerance); t.After(at) {
+        at = t
+    }
+    if t := limiter.last.Add(limiter.minDelay); limiter.last.IsZero() == false && t.After(at) {
+        at = t
+    }
+    if limiter.pausedUntil.After(at) {
+        at = limiter.pausedUntil
+    }
+
+    if limiter.next.Before(at) {
+        limiter.next = at
+    }
+    limiter.next = limiter.next.Add(limiter.interval)
+    limiter.last = at
+    return at
+}
+
+
+// Wait blocks until the caller is allowed to make a request, or ctx is done.
+// A nil Limiter never blocks.
+func ( limiter *Limiter ) Wait( ctx context.Context ) error {
+
+    if limiter == nil {
+        return ctx.Err()
+    }
+    delay := time.Until( limiter.reserve() )
+    if delay <= 0 {
+        return ctx.Err()
+    }
+
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+        case <- timer.C:
+            return nil
+        case <- ctx.Done():
+            return ctx.Err()
+    }
+}
+
+
+// Backoff stops all requests for d, ex: when the server says it's overloaded
+func ( limiter *Limiter ) Backoff( d time.Duration ) {
+
+    if limiter == nil {
+        return
+    }
+    if d > MAX_BACKOFF {
+        d = MAX_BACKOFF
+    }
+    limiter.mu.Lock()
+    defer limiter.mu.Unlock()
+    if until := time.Now().Add(d); until.After(limiter.pausedUntil) {
+        limiter.pausedUntil = until
+    }
+}
+
+
+// HostLimiter keeps one Limiter per host, so each host in scope sees the rate on its own
+type HostLimiter struct {
+
+    mu sync.Mutex
+    rate float64                    // requests per second to each host
+    burst int                       // requests allowed at once to each host
+    minDelay time.Duration          // minimum time between two requests to a host
+    limiters map[string]*Limiter    // limiters by lowercased host (with port)
+
+}
+
+
+// NewHostLimiter creates a HostLimiter giving each host a Limiter for rate requests per second,
+// with bursts of burst requests, and at least minDelay between requests
+func NewHostLimiter( rate float64, burst int, minDelay time.Duration ) *HostLimiter {
+    return &HostLimiter{ rate: rate, burst: burst, minDelay: minDelay, limiters: make( map[string]*Limiter ) }
+}
+
+
+// For returns the Limiter of host, creating it on first use.
+// A nil HostLimiter returns a nil Limiter, that never blocks.
+func ( hosts *HostLimiter ) For( host string ) *Limiter {
+
+    if hosts == nil {
+        return nil
+    }
+    host = strings.ToLower( host )
+    hosts.mu.Lock()
+    defer hosts.mu.Unlock()
+    limiter, ok := hosts.limiters[host]
+    if ok == false {
+        limiter = NewLimiter( hosts.rate, hosts.burst, hosts.minDelay )
+        hosts.limiters[host] = limiter
+    }
+    return limiter
+}
+
+
+// SetMinDelay raises the minimum time between requests to host to d, ex: for its robots.txt Crawl-delay
+func ( hosts *HostLimiter ) SetMinDelay( host string, d time.Duration ) {
+
+    limiter := hosts.For( host )
+    if limiter == nil {
+        return
+    }
+    limiter.mu.Lock()
+    defer limiter.mu.Unlock()
+    if d > limiter.minDelay {
+        limiter.minDelay = d
+    }
+}
+
+
+// retryAfter reads how long a 429 or 503 response asks us to wait,
+// from a Retry-After header in seconds or as an http date
+func retryAfter( resp *http.Response ) time.Duration {
+
+    header := resp.Header.Get("Retry-After")
+    if header == "" {
+        return DEFAULT_BACKOFF
+    }
+    if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+        return time.Duration(secs) * time.Second
+    }
+    if t, err := http.ParseTime(header); err == nil {
+        return time.Until(t)
+    }
+    return DEFAULT_BACKOFF
+}
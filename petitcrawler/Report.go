@@ -0,0 +1,306 @@
+package petitcrawler
+
+
+import (
+    "html/template"
+    "net/url"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+
+// ReportOutput writes a self-contained HTML report of the crawl, for people rather than tools:
+// summary statistics, a sortable and filterable table of the pages, broken links with the pages
+// linking to them, redirect chains, and a collapsible tree of the site by URL path.
+// Everything, styles and scripts included, is in the one file. The pages are held in memory to build it.
+type ReportOutput struct {
+    started time.Time
+}
+
+func ( out *ReportOutput ) Extension() string { return ".html" }
+func ( out *ReportOutput ) Page( crawler *SingleCrawler, page Page ) error { return nil }
+
+func ( out *ReportOutput ) Begin( crawler *SingleCrawler ) error {
+    out.started = time.Now()
+    return nil
+}
+
+
+// reportCount is one row of a table of counts, ex: pages per status
+type reportCount struct {
+    Label string
+    Count int
+}
+
+
+// reportTree is a path segment in the tree view of the site, with the page at that path if it was collected
+type reportTree struct {
+    Name string
+    Page *Page
+    Pages int           // collected pages at or under this path
+    Children []*reportTree
+}
+
+
+// reportData is what the report template is rendered with
+type reportData struct {
+    Site string
+    Reason StopReason
+    Started time.Time
+    Finished time.Time
+    Pages []Page
+    Failed []Fetch
+    NonHTML []Fetch
+    Excluded []JSONExcluded
+    Redirected []Page
+    NearDuplicates [][]string
+    Statuses []reportCount
+    Depths []reportCount
+    Bytes int64
+    AvgResponse time.Duration
+    Tree *reportTree
+}
+
+
+func ( out *ReportOutput ) End( crawler *SingleCrawler ) error {
+
+    data := reportData{ Site: crawler.Site.String(), Reason: crawler.Reason, Started: out.started, Finished: time.Now(),
+        Failed: crawler.Failed, NonHTML: crawler.NonHTML, Excluded: jsonExcluded( crawler ),
+        Tree: &reportTree{ Name: crawler.Site.Scheme + "://" + crawler.Site.Host } }
+    if data.Started.IsZero() {
+        data.Started = data.Finished
+    }
+
+    statuses := make( map[int]int )
+    var depths []int
+    var total time.Duration
+    err := crawler.EachPage( func( p Page ) error {
+        data.Pages = append( data.Pages, p )
+        statuses[p.Status]++
+        for len(depths) <= p.Depth {
+            depths = append( depths, 0 )
+        }
+        depths[p.Depth]++
+        data.Bytes += p.ContentLength
+        total += p.ResponseTime
+        if len(p.Redirects) > 0 {
+            data.Redirected = append( data.Redirected, p )
+        }
+        return nil
+    })
+    if err == nil {
+        data.NearDuplicates, err = crawler.NearDuplicates()
+    }
+    if err != nil {
+        return err
+    }
+    if len(data.Pages) > 0 {
+        data.AvgResponse = total / time.Duration( len(data.Pages) )
+    }
+    for _, f := range crawler.Failed {
+        statuses[f.Status]++
+    }
+    codes := make( []int, 0, len(statuses) )
+    for code := range statuses {
+        codes = append( codes, code )
+    }
+    sort.Ints( codes )
+    for _, code := range codes {
+        label := strconv.Itoa(code)
+        if code == 0 {
+            label = "no response"
+        }
+        data.Statuses = append( data.Statuses, reportCount{ label, statuses[code] } )
+    }
+    for depth, n := range depths {
+        data.Depths = append( data.Depths, reportCount{ strconv.Itoa(depth), n } )
+    }
+    for i := range data.Pages {
+        data.Tree.add( &data.Pages[i] )
+    }
+
+    file, err := os.Create( crawler.Filename )
+    if err != nil {
+        return err
+    }
+    err = reportTemplate.Execute( file, data )
+    if cerr := file.Close(); err == nil {
+        err = cerr
+    }
+    return err
+}
+
+
+// add puts page in the tree under the path segments of its final URL, keeping children sorted
+func ( tree *reportTree ) add( page *Page ) {
+
+    link := page.MyUrl
+    if page.FinalURL != "" {
+        link = page.FinalURL
+    }
+    u, err := url.Parse( link )
+    if err != nil {
+        return
+    }
+    node := tree
+    node.Pages++
+    for _, segment := range strings.Split( strings.Trim( u.Path, "/" ), "/" ) {
+        if segment == "" {
+            continue
+        }
+        i := sort.Search( len(node.Children), func( i int ) bool { return node.Children[i].Name >= segment } )
+        if i == len(node.Children) || node.Children[i].Name != segment {
+            node.Children = append( node.Children, nil )
+            copy( node.Children[i+1:], node.Children[i:] )
+            node.Children[i] = &reportTree{ Name: segment }
+        }
+        node = node.Children[i]
+        node.Pages++
+    }
+    if node.Page == nil {
+        node.Page = page
+    }
+}
+
+
+var reportTemplate = template.Must( template.New("report").Funcs( template.FuncMap{
+    "ms": func( d time.Duration ) int64 { return d.Milliseconds() },
+    "kb": func( n int64 ) string { return strconv.FormatFloat( float64(n) / 1024, 'f', 1, 64 ) },
+    "join": strings.Join,
+}).Parse( reportHTML ) )
+
+
+const reportHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Crawl report: {{.Site}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; } h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; font-size: 0.9em; }
+th, td { border: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+table.sortable th { cursor: pointer; user-select: none; }
+table.sortable th:after { content: " \2195"; color: #aaa; }
+.bad { color: #b00; } .num { text-align: right; }
+.stats td:first-child { font-weight: bold; }
+details { margin-left: 1.2em; } summary { cursor: pointer; } .leaf { margin-left: 2.4em; }
+.counts { display: flex; gap: 2em; margin-top: 1em; }
+#filter { margin: 0.5em 0; padding: 0.3em; width: 30em; }
+</style>
+</head>
+<body>
+<h1>Crawl report: <a href="{{.Site}}">{{.Site}}</a></h1>
+
+<h2>Summary</h2>
+<table class="stats">
+<tr><td>Started</td><td>{{.Started.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Finished</td><td>{{.Finished.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Stopped because</td><td>{{.Reason}}</td></tr>
+<tr><td>Pages</td><td>{{len .Pages}}</td></tr>
+<tr><td>Broken links</td><td>{{len .Failed}}</td></tr>
+<tr><td>Non-HTML URLs</td><td>{{len .NonHTML}}</td></tr>
+<tr><td>Excluded URLs</td><td>{{len .Excluded}}</td></tr>
+<tr><td>Redirected pages</td><td>{{len .Redirected}}</td></tr>
+<tr><td>Near duplicate clusters</td><td>{{len .NearDuplicates}}</td></tr>
+<tr><td>Total size</td><td>{{kb .Bytes}} KB</td></tr>
+<tr><td>Average response time</td><td>{{ms .AvgResponse}} ms</td></tr>
+</table>
+<div class="counts">
+<table class="stats">
+<tr><th>Status</th><th>URLs</th></tr>
+{{range .Statuses}}<tr><td>{{.Label}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+<table class="stats">
+<tr><th>Depth</th><th>Pages</th></tr>
+{{range .Depths}}<tr><td>{{.Label}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+</div>
+
+<h2>Pages</h2>
+<input id="filter" type="search" placeholder="Filter pages">
+<table id="pages" class="sortable">
+<thead><tr><th>URL</th><th>Status</th><th>Title</th><th>Depth</th><th>Size (bytes)</th><th>Time (ms)</th><th>Links</th><th>Assets</th></tr></thead>
+<tbody>
+{{range .Pages}}<tr><td><a href="{{.MyUrl}}">{{.MyUrl}}</a>{{if .Aliases}}<br><small>duplicates: {{join .Aliases ", "}}</small>{{end}}</td><td class="num">{{.Status}}</td><td>{{.Title}}</td><td class="num">{{.Depth}}</td><td class="num">{{.ContentLength}}</td><td class="num">{{ms .ResponseTime}}</td><td class="num">{{len .Anchors}}</td><td class="num">{{len .Assets}}</td></tr>
+{{end}}</tbody>
+</table>
+
+<h2>Broken links</h2>
+{{if .Failed}}<table id="broken" class="sortable">
+<thead><tr><th>URL</th><th>Status</th><th>Error</th><th>Attempts</th><th>Linked from</th></tr></thead>
+<tbody>
+{{range .Failed}}<tr><td class="bad">{{.URL}}</td><td class="num">{{.Status}}</td><td>{{.Err}}</td><td class="num">{{len .Attempts}}</td><td>{{range .Referrers}}<a href="{{.}}">{{.}}</a><br>{{end}}</td></tr>
+{{end}}</tbody>
+</table>{{else}}<p>None.</p>{{end}}
+
+<h2>Redirect chains</h2>
+{{if .Redirected}}<table id="redirects">
+<thead><tr><th>Chain</th><th>Hops</th></tr></thead>
+<tbody>
+{{range .Redirected}}<tr><td>{{range .Redirects}}{{.URL}} ({{.Status}}) &rarr; {{end}}<a href="{{.FinalURL}}">{{.FinalURL}}</a></td><td class="num">{{len .Redirects}}</td></tr>
+{{end}}</tbody>
+</table>{{else}}<p>None.</p>{{end}}
+
+{{if .NonHTML}}<h2>Non-HTML URLs</h2>
+<table id="nonhtml" class="sortable">
+<thead><tr><th>URL</th><th>Content type</th><th>Linked from</th></tr></thead>
+<tbody>
+{{range .NonHTML}}<tr><td><a href="{{.URL}}">{{.URL}}</a></td><td>{{.ContentType}}</td><td>{{range .Referrers}}<a href="{{.}}">{{.}}</a><br>{{end}}</td></tr>
+{{end}}</tbody>
+</table>{{end}}
+
+{{if .NearDuplicates}}<h2>Near duplicate pages</h2>
+<ul>
+{{range .NearDuplicates}}<li>{{range .}}<a href="{{.}}">{{.}}</a><br>{{end}}</li>
+{{end}}</ul>{{end}}
+
+<h2>Site tree</h2>
+<div id="tree">{{template "tree" .Tree}}</div>
+
+{{if .Excluded}}<h2>Excluded URLs</h2>
+<table id="excluded" class="sortable">
+<thead><tr><th>URL</th><th>Reason</th></tr></thead>
+<tbody>
+{{range .Excluded}}<tr><td>{{.URL}}</td><td>{{.Reason}}</td></tr>
+{{end}}</tbody>
+</table>{{end}}
+
+<script>
+// Sort a table by the clicked column, numbers as numbers, clicking again reverses it
+document.querySelectorAll("table.sortable th").forEach(function (th) {
+    th.addEventListener("click", function () {
+        var table = th.closest("table"), body = table.tBodies[0], col = th.cellIndex;
+        var asc = th.dataset.order !== "asc";
+        th.parentNode.querySelectorAll("th").forEach(function (h) { delete h.dataset.order; });
+        th.dataset.order = asc ? "asc" : "desc";
+        var rows = Array.prototype.slice.call(body.rows);
+        rows.sort(function (a, b) {
+            var x = a.cells[col].textContent.trim(), y = b.cells[col].textContent.trim();
+            var nx = parseFloat(x), ny = parseFloat(y);
+            var c = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+            return asc ? c : -c;
+        });
+        rows.forEach(function (r) { body.appendChild(r); });
+    });
+});
+// Show only the pages whose row contains the filter text
+document.getElementById("filter").addEventListener("input", function () {
+    var q = this.value.toLowerCase();
+    Array.prototype.forEach.call(document.getElementById("pages").tBodies[0].rows, function (r) {
+        r.style.display = r.textContent.toLowerCase().indexOf(q) === -1 ? "none" : "";
+    });
+});
+</script>
+</body>
+</html>
+{{define "tree"}}{{if .Children}}<details open><summary>{{template "node" .}} <small>({{.Pages}} pages)</small></summary>
+{{range .Children}}{{template "tree" .}}{{end}}</details>
+{{else}}<div class="leaf">{{template "node" .}}</div>
+{{end}}{{end}}
+{{define "node"}}{{if .Page}}<a href="{{.Page.MyUrl}}">{{.Name}}</a>{{if .Page.Title}} &ndash; {{.Page.Title}}{{end}}{{if ge .Page.Status 400}} <span class="bad">{{.Page.Status}}</span>{{end}}{{else}}{{.Name}}{{end}}{{end}}
+`
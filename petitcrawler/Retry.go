@@ -0,0 +1,141 @@
+package petitcrawler
+
+
+import (
+    "context"
+    "errors"
+    "io"
+    "math/rand"
+    "net"
+    "net/http"
+    "time"
+)
+
+
+// RetryPolicy decides which failed requests are tried again, and how long to wait in between
+type RetryPolicy struct {
+
+    MaxAttempts int                 // total attempts per URL, including the first
+    BaseDelay time.Duration         // wait before the first retry, doubled for each one after
+    MaxDelay time.Duration          // cap on the wait between attempts
+    Jitter float64                  // fraction (0 to 1) of each wait that is randomized
+    RetryStatus []int               // http status codes worth retrying
+    RetryError func(error) bool     // transport errors worth retrying, nil means DefaultRetryError
+
+}
+
+
+// Default retry policy: 3 attempts, retrying timeouts, dropped connections and server errors
+var DEFAULT_RETRY_POLICY = RetryPolicy{
+    MaxAttempts: 3,
+    BaseDelay: 500 * time.Millisecond,
+    MaxDelay: 30 * time.Second,
+    Jitter: 0.5,
+    RetryStatus: []int{ http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+        http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout },
+}
+
+
+// Attempt records one request made for a URL
+type Attempt struct {
+
+    Time time.Time              // when the request was sent
+    Duration time.Duration      // how long until the response (or error)
+    Status int                  // http status code, 0 if there was no response
+    Err string                  // why the attempt failed, empty on success
+    Retryable bool              // if the failure was one the retry policy retries
+
+}
+
+
+// Fetch records how crawling one URL went, across all its attempts
+type Fetch struct {
+
+    URL string
+    Referrers []string      // URLs of the collected pages that link to it
+    Status int              // http status code of the last attempt, 0 if there was no response
+    Attempts []Attempt
+    Err string              // why the URL failed, empty if it was crawled
+    Transient bool          // failed only with retryable errors, so it may work later
+    NotHTML bool            // the URL was fetched, but isn't an HTML page
+    ContentType string      // content type of a URL that isn't an HTML page
+    Disallowed bool         // the URL wasn't fetched, its host's robots.txt disallows it
+
+}
+
+
+// Retryable reports if a request that got status (0 for no response) and err should be tried again
+func ( policy *RetryPolicy ) Retryable( status int, err error ) bool {
+
+    if err != nil {
+        if policy.RetryError != nil {
+            return policy.RetryError(err)
+        }
+        return DefaultRetryError(err)
+    }
+    for _, s := range policy.RetryStatus {
+        if s == status {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Backoff returns how long to wait after the given (1 based) attempt failed,
+// growing exponentially from BaseDelay up to MaxDelay (no cap if 0), with jitter
+func ( policy *RetryPolicy ) Backoff( attempt int ) time.Duration {
+
+    delay := policy.BaseDelay
+    for i := 1; i < attempt && (policy.MaxDelay == 0 || delay < policy.MaxDelay); i++ {
+        delay *= 2
+    }
+    if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+        delay = policy.MaxDelay
+    }
+    if policy.Jitter > 0 {
+        delay -= time.Duration( policy.Jitter * rand.Float64() * float64(delay) )
+    }
+    return delay
+}
+
+
+// DefaultRetryError retries timeouts, refused/reset connections, connections closed early
+// and temporary DNS failures. A cancelled context is never retried.
+// A request that ran past the client's Timeout is retried, fetch stops retrying once the crawl itself is done.
+func DefaultRetryError( err error ) bool {
+
+    if errors.Is(err, context.Canceled) {
+        return false
+    }
+    if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+        return true
+    }
+    var dnsErr *net.DNSError
+    if errors.As(err, &dnsErr) {
+        return dnsErr.IsTimeout || dnsErr.IsTemporary
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return true
+    }
+    var opErr *net.OpError
+    return errors.As(err, &opErr)
+}
+
+
+// sleep waits for d, or until ctx is done
+func sleep( ctx context.Context, d time.Duration ) error {
+
+    if d <= 0 {
+        return ctx.Err()
+    }
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+        case <- timer.C:
+            return nil
+        case <- ctx.Done():
+            return ctx.Err()
+    }
+}
@@ -0,0 +1,340 @@
+package petitcrawler
+
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "github.com/golang/glog"
+)
+
+
+// Robots holds the rules parsed from a site's robots.txt
+type Robots struct {
+
+    groups []robotsGroup    // rule groups, in the order found in the file
+    Sitemaps []string       // Sitemap: directives, these apply to all user agents
+    DisallowAll bool        // set when robots.txt was unreachable, nothing may be crawled
+
+}
+
+
+// One group of robots.txt rules, for the user agents listed at its start
+type robotsGroup struct {
+    agents []string
+    rules []robotsRule
+    crawlDelay time.Duration
+    hasDelay bool
+}
+
+
+// One Allow or Disallow line
+type robotsRule struct {
+    allow bool
+    pattern string
+}
+
+
+// ParseRobots reads a robots.txt file.
+// Unknown directives and lines that can't be understood are skipped.
+func ParseRobots( r io.Reader ) *Robots {
+
+    robots := &Robots{}
+    var group *robotsGroup
+    lastWasAgent := false
+
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+
+        line := scanner.Text()
+        if i := strings.Index(line, "#"); i >= 0 {
+            line = line[:i]
+        }
+        colon := strings.Index(line, ":")
+        if colon < 0 {
+            continue
+        }
+        key := strings.ToLower( strings.TrimSpace(line[:colon]) )
+        val := strings.TrimSpace(line[colon+1:])
+
+        switch key {
+            case "user-agent":
+                // Consecutive user-agent lines share one group
+                if group == nil || lastWasAgent == false {
+                    robots.groups = append( robots.groups, robotsGroup{} )
+                    group = &robots.groups[len(robots.groups)-1]
+                }
+                group.agents = append( group.agents, strings.ToLower(val) )
+                lastWasAgent = true
+                continue
+            case "allow", "disallow":
+                // An empty Disallow means nothing is disallowed
+                if group != nil && val != "" {
+                    group.rules = append( group.rules, robotsRule{ allow: key == "allow", pattern: val } )
+                }
+            case "crawl-delay":
+                if group != nil {
+                    if secs, err := strconv.ParseFloat(val, 64); err == nil && secs >= 0 {
+                        group.crawlDelay = time.Duration( secs * float64(time.Second) )
+                        group.hasDelay = true
+                    }
+                }
+            case "sitemap":
+                if val != "" {
+                    robots.Sitemaps = append( robots.Sitemaps, val )
+                }
+        }
+        lastWasAgent = false
+    }
+
+    return robots
+}
+
+
+// FetchRobots gets and parses /robots.txt for site.
+// Following RFC 9309, a missing robots.txt (4xx) allows everything, while an
+// unreachable one (5xx or no response) disallows everything.
+func FetchRobots( ctx context.Context, client *http.Client, site *url.URL, userAgent string ) (*Robots, error) {
+
+    robotsURL := url.URL{ Scheme: site.Scheme, Host: site.Host, Path: "/robots.txt" }
+    req, err := http.NewRequestWithContext( ctx, "GET", robotsURL.String(), nil )
+    if err != nil {
+        return nil, err
+    }
+    if userAgent != "" {
+        req.Header.Set( "User-Agent", userAgent )
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        if ctx.Err() != nil {
+            return nil, ctx.Err()
+        }
+        glog.Warning( fmt.Sprintf("Unable to fetch %s. Error is %s. Not crawling anything.", robotsURL.String(), err))
+        return &Robots{ DisallowAll: true }, nil
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 500 {
+        glog.Warning( fmt.Sprintf("Bad response fetching %s. Error code %d. Not crawling anything.", robotsURL.String(), resp.StatusCode))
+        return &Robots{ DisallowAll: true }, nil
+    }
+    if resp.StatusCode >= 400 {
+        glog.Info( fmt.Sprintf("No robots.txt at %s (code %d), allowing everything.", robotsURL.String(), resp.StatusCode))
+        return &Robots{}, nil
+    }
+
+    // Only look at the first 500 KiB, like the major search engines do
+    return ParseRobots( io.LimitReader(resp.Body, 500*1024) ), nil
+}
+
+
+// RobotsCache fetches the robots.txt of each host the first time it's needed, and keeps it for all workers.
+// A host's Crawl-delay is set on its Limiter.
+type RobotsCache struct {
+
+    client *http.Client
+    userAgent string
+    limiters *HostLimiter
+    mu sync.Mutex
+    hosts map[string]*robotsEntry   // by lowercased scheme://host
+
+}
+
+
+// One host's robots.txt, ready is closed once it has been fetched
+type robotsEntry struct {
+    ready chan struct{}
+    robots *Robots
+    err error
+}
+
+
+// NewRobotsCache creates a RobotsCache fetching with client as userAgent, and setting Crawl-delays on limiters
+func NewRobotsCache( client *http.Client, userAgent string, limiters *HostLimiter ) *RobotsCache {
+    return &RobotsCache{ client: client, userAgent: userAgent, limiters: limiters, hosts: make( map[string]*robotsEntry ) }
+}
+
+
+// Get returns the robots.txt rules of u's host, fetching them if no one has yet.
+// Callers asking for a host being fetched wait for it, or until ctx is done.
+func ( cache *RobotsCache ) Get( ctx context.Context, u *url.URL ) (*Robots, error) {
+
+    host := strings.ToLower( u.Scheme + "://" + u.Host )
+    cache.mu.Lock()
+    entry, ok := cache.hosts[host]
+    if ok == false {
+        entry = &robotsEntry{ ready: make( chan struct{} ) }
+        cache.hosts[host] = entry
+    }
+    cache.mu.Unlock()
+
+    if ok {
+        select {
+            case <- entry.ready:
+                return entry.robots, entry.err
+            case <- ctx.Done():
+                return nil, ctx.Err()
+        }
+    }
+
+    entry.robots, entry.err = FetchRobots( ctx, cache.client, u, cache.userAgent )
+    if entry.err != nil {
+        // Only a cancelled fetch fails, let the next caller try again
+        cache.mu.Lock()
+        delete( cache.hosts, host )
+        cache.mu.Unlock()
+    } else if delay, ok := entry.robots.CrawlDelay( cache.userAgent ); ok {
+        glog.Info( fmt.Sprintf("Using robots.txt Crawl-delay of %s for %s", delay, host) )
+        cache.limiters.SetMinDelay( u.Host, delay )
+    }
+    close( entry.ready )
+    return entry.robots, entry.err
+}
+
+
+// All returns the robots.txt rules fetched so far, by scheme://host
+func ( cache *RobotsCache ) All() map[string]*Robots {
+
+    all := make( map[string]*Robots )
+    cache.mu.Lock()
+    defer cache.mu.Unlock()
+    for host, entry := range cache.hosts {
+        select {
+            case <- entry.ready:
+                if entry.err == nil {
+                    all[host] = entry.robots
+                }
+            default:
+        }
+    }
+    return all
+}
+
+
+// group finds the rule groups that apply to userAgent: the ones with the most
+// specific matching user-agent, falling back to the '*' groups.
+func ( robots *Robots ) group( userAgent string ) []*robotsGroup {
+
+    // Match on the product token, ex: "petitcrawler" in "petitcrawler/1.0 (+http://...)"
+    token := strings.ToLower(userAgent)
+    if i := strings.IndexAny(token, "/ "); i >= 0 {
+        token = token[:i]
+    }
+
+    var best []*robotsGroup
+    bestLen := -1
+    for i := range robots.groups {
+        g := &robots.groups[i]
+        for _, agent := range g.agents {
+            n := -1
+            if agent == "*" {
+                n = 0
+            } else if token != "" && strings.HasPrefix(token, agent) {
+                n = len(agent)
+            }
+            if n > bestLen {
+                best = []*robotsGroup{ g }
+                bestLen = n
+            } else if n == bestLen && n >= 0 {
+                best = append( best, g )
+            }
+        }
+    }
+    return best
+}
+
+
+// Allowed reports if userAgent may crawl the URL u.
+// The longest matching rule wins, with Allow winning ties.
+func ( robots *Robots ) Allowed( userAgent string, u *url.URL ) bool {
+
+    if robots == nil {
+        return true
+    }
+    path := u.EscapedPath()
+    if path == "" {
+        path = "/"
+    }
+    if u.RawQuery != "" {
+        path += "?" + u.RawQuery
+    }
+    if path == "/robots.txt" {
+        return true
+    }
+    if robots.DisallowAll {
+        return false
+    }
+
+    allowed := true
+    longest := -1
+    for _, g := range robots.group( userAgent ) {
+        for _, rule := range g.rules {
+            if len(rule.pattern) < longest || robotsMatch(rule.pattern, path) == false {
+                continue
+            }
+            if len(rule.pattern) > longest {
+                allowed = rule.allow
+            } else {
+                allowed = allowed || rule.allow
+            }
+            longest = len(rule.pattern)
+        }
+    }
+    return allowed
+}
+
+
+// CrawlDelay returns the Crawl-delay for userAgent, and if one was given
+func ( robots *Robots ) CrawlDelay( userAgent string ) (time.Duration, bool) {
+
+    if robots == nil {
+        return 0, false
+    }
+    for _, g := range robots.group( userAgent ) {
+        if g.hasDelay {
+            return g.crawlDelay, true
+        }
+    }
+    return 0, false
+}
+
+
+// robotsMatch matches a robots.txt path pattern against path.
+// '*' matches any run of characters and a trailing '$' anchors the end of the path,
+// otherwise the pattern only has to match a prefix of the path.
+func robotsMatch( pattern string, path string ) bool {
+
+    anchored := strings.HasSuffix(pattern, "$")
+    if anchored {
+        pattern = pattern[:len(pattern)-1]
+    }
+
+    parts := strings.Split(pattern, "*")
+    if strings.HasPrefix(path, parts[0]) == false {
+        return false
+    }
+    if len(parts) == 1 {
+        return anchored == false || path == parts[0]
+    }
+
+    pos := len(parts[0])
+    for i := 1; i < len(parts); i++ {
+        if i == len(parts)-1 && anchored {
+            return len(path) - pos >= len(parts[i]) && strings.HasSuffix(path, parts[i])
+        }
+        idx := strings.Index(path[pos:], parts[i])
+        if idx < 0 {
+            return false
+        }
+        pos += idx + len(parts[i])
+    }
+    return true
+}
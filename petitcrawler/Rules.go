@@ -0,0 +1,110 @@
+package petitcrawler
+
+
+import (
+    "errors"
+    "fmt"
+    "net/url"
+    "regexp"
+    "strings"
+)
+
+
+// RuleKind is how a Rule's pattern is matched
+type RuleKind int
+
+const (
+    RulePrefix RuleKind = iota      // the URL path starts with the pattern, ex: /docs/ (which also matches /docs)
+    RuleGlob                        // the URL path (and query) matches the pattern, '*' is any run of characters and '?' one character
+    RuleRegexp                      // the full URL matches the regular expression
+)
+
+
+// Rule is one include or exclude pattern for URLs
+type Rule struct {
+
+    Kind RuleKind
+    Pattern string
+    re *regexp.Regexp       // compiled pattern for globs and regular expressions
+
+}
+
+
+// NewRule creates a Rule, compiling its pattern
+func NewRule( kind RuleKind, pattern string ) (Rule, error) {
+
+    rule := Rule{ Kind: kind, Pattern: pattern }
+    if pattern == "" {
+        return rule, errors.New("Rule pattern can't be empty.")
+    }
+
+    var err error
+    switch kind {
+        case RulePrefix:
+        case RuleGlob:
+            expr := regexp.QuoteMeta(pattern)
+            expr = strings.ReplaceAll( expr, `\*`, ".*" )
+            expr = strings.ReplaceAll( expr, `\?`, "." )
+            rule.re, err = regexp.Compile( "^" + expr + "$" )
+        case RuleRegexp:
+            rule.re, err = regexp.Compile( pattern )
+        default:
+            return rule, errors.New( fmt.Sprintf("Unknown rule kind %d.", kind))
+    }
+    if err != nil {
+        return rule, errors.New( fmt.Sprintf("Bad rule pattern %s. Error is %s.", pattern, err))
+    }
+    return rule, nil
+}
+
+
+// ParseRule reads a rule written as "prefix:/docs/", "glob:/calendar/*" or "regexp:[?&]sessionid=".
+// Without a kind the pattern is a path prefix.
+func ParseRule( s string ) (Rule, error) {
+
+    for kind, name := range map[RuleKind]string{ RulePrefix: "prefix:", RuleGlob: "glob:", RuleRegexp: "regexp:" } {
+        if strings.HasPrefix( s, name ) {
+            return NewRule( kind, s[len(name):] )
+        }
+    }
+    return NewRule( RulePrefix, s )
+}
+
+
+// Match reports if u matches the rule
+func ( rule Rule ) Match( u *url.URL ) bool {
+
+    path := u.EscapedPath()
+    if path == "" {
+        path = "/"
+    }
+    switch rule.Kind {
+        case RulePrefix:
+            // The normalizer may have folded the trailing slash of the directory itself
+            if len(rule.Pattern) > 1 && path == strings.TrimSuffix( rule.Pattern, "/" ) {
+                return true
+            }
+            return strings.HasPrefix( path, rule.Pattern )
+        case RuleGlob:
+            if u.RawQuery != "" && rule.re.MatchString( path + "?" + u.RawQuery ) {
+                return true
+            }
+            return rule.re.MatchString( path )
+        case RuleRegexp:
+            return rule.re.MatchString( u.String() )
+    }
+    return false
+}
+
+
+// String writes the rule the way ParseRule reads it
+func ( rule Rule ) String() string {
+
+    switch rule.Kind {
+        case RuleGlob:
+            return "glob:" + rule.Pattern
+        case RuleRegexp:
+            return "regexp:" + rule.Pattern
+    }
+    return "prefix:" + rule.Pattern
+}
@@ -0,0 +1,145 @@
+package petitcrawler
+
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "net/url"
+    "strings"
+    "golang.org/x/net/publicsuffix"
+)
+
+
+// ScopeMode picks which hosts count as part of the crawled site
+type ScopeMode int
+
+const (
+    ScopeExactHost ScopeMode = iota     // only the start URL's host
+    ScopeWWW                            // the start URL's host, with or without a leading www.
+    ScopeSubdomains                     // any host under the start URL's registrable domain, ex: *.example.co.uk
+    ScopeAllowList                      // the start URL's host, and an explicit list of hosts
+)
+
+
+// Scope decides whether a URL is part of the crawled site, by its host,
+// and by the include and exclude rules for its path
+type Scope struct {
+
+    Mode ScopeMode
+    Host string         // host (and port) of the start URL, lowercased
+    Domain string       // registrable domain of the start URL, used by ScopeSubdomains
+    Hosts []string      // extra hosts allowed by ScopeAllowList, lowercased
+    Include []Rule      // if not empty, only URLs matching one of these are crawled
+    Exclude []Rule      // URLs matching any of these are never crawled
+
+}
+
+
+// NewScope creates a Scope of the given mode around the start URL site.
+// hosts is the allow list for ScopeAllowList, and is ignored by the other modes.
+func NewScope( mode ScopeMode, site *url.URL, hosts ...string ) (*Scope, error) {
+
+    if site == nil {
+        return nil, errors.New("Scope needs a start URL.")
+    }
+    if err := DomainCheck( site ); err != nil {
+        return nil, err
+    }
+    scope := &Scope{ Mode: mode, Host: strings.ToLower(site.Host) }
+
+    switch mode {
+        case ScopeExactHost, ScopeWWW:
+        case ScopeSubdomains:
+            // IP addresses and hosts like localhost don't have a registrable domain, so use the host
+            hostname := strings.ToLower( site.Hostname() )
+            scope.Domain = hostname
+            if net.ParseIP(hostname) == nil {
+                if domain, err := publicsuffix.EffectiveTLDPlusOne( hostname ); err == nil {
+                    scope.Domain = domain
+                }
+            }
+        case ScopeAllowList:
+            for _, h := range hosts {
+                if h = strings.ToLower( strings.TrimSpace(h) ); h != "" {
+                    scope.Hosts = append( scope.Hosts, h )
+                }
+            }
+        default:
+            return nil, errors.New( fmt.Sprintf("Unknown scope mode %d.", mode))
+    }
+    return scope, nil
+}
+
+
+// InScope reports if u is an http(s) URL that belongs to the crawled site
+func ( scope *Scope ) InScope( u *url.URL ) bool {
+
+    if scope == nil || u == nil {
+        return false
+    }
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return false
+    }
+    host := strings.ToLower( u.Host )
+    if host == scope.Host {
+        return true
+    }
+
+    switch scope.Mode {
+        case ScopeWWW:
+            return strings.TrimPrefix( host, "www." ) == strings.TrimPrefix( scope.Host, "www." )
+        case ScopeSubdomains:
+            hostname := strings.ToLower( u.Hostname() )
+            return hostname == scope.Domain || strings.HasSuffix( hostname, "." + scope.Domain )
+        case ScopeAllowList:
+            hostname := strings.ToLower( u.Hostname() )
+            for _, h := range scope.Hosts {
+                if h == host || h == hostname {
+                    return true
+                }
+            }
+    }
+    return false
+}
+
+
+// Allowed reports if u should be crawled: it must be in scope, match no exclude rule,
+// and match an include rule if there are any. When u is rejected, reason says why.
+func ( scope *Scope ) Allowed( u *url.URL ) (bool, string) {
+
+    if scope.InScope( u ) == false {
+        return false, "out of scope"
+    }
+    for _, rule := range scope.Exclude {
+        if rule.Match(u) {
+            return false, "exclude " + rule.String()
+        }
+    }
+    if len(scope.Include) == 0 {
+        return true, ""
+    }
+    for _, rule := range scope.Include {
+        if rule.Match(u) {
+            return true, ""
+        }
+    }
+    return false, "no include rule matched"
+}
+
+
+// ParseScopeMode turns a name (exact, www, subdomains, allowlist) into a ScopeMode
+func ParseScopeMode( name string ) (ScopeMode, error) {
+
+    switch strings.ToLower(name) {
+        case "exact":
+            return ScopeExactHost, nil
+        case "www":
+            return ScopeWWW, nil
+        case "subdomains":
+            return ScopeSubdomains, nil
+        case "allowlist":
+            return ScopeAllowList, nil
+    }
+    return ScopeWWW, errors.New( fmt.Sprintf("Unknown scope %s, must be exact, www, subdomains or allowlist.", name))
+}
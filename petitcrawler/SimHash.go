@@ -0,0 +1,120 @@
+package petitcrawler
+
+
+import (
+    "hash/fnv"
+    "math/bits"
+    "strings"
+)
+
+
+// Number of words in each shingle of text SimHash fingerprints
+const SIMHASH_SHINGLE = 3
+
+
+// SimHash returns a 64 bit fingerprint of text, from its overlapping shingles of SIMHASH_SHINGLE words.
+// Texts that share most of their shingles get fingerprints a few bits apart, see HammingDistance.
+func SimHash( text string ) uint64 {
+
+    words := strings.Fields( strings.ToLower(text) )
+    var weights [64]int
+    add := func( shingle []string ) {
+        h := fnv.New64a()
+        h.Write( []byte( strings.Join( shingle, " " ) ) )
+        sum := h.Sum64()
+        for i := 0; i < 64; i++ {
+            if sum & (1 << uint(i)) != 0 {
+                weights[i]++
+            } else {
+                weights[i]--
+            }
+        }
+    }
+    if len(words) < SIMHASH_SHINGLE {
+        if len(words) > 0 {
+            add( words )
+        }
+    } else {
+        for i := 0; i + SIMHASH_SHINGLE <= len(words); i++ {
+            add( words[i:i+SIMHASH_SHINGLE] )
+        }
+    }
+
+    var fingerprint uint64
+    for i, w := range weights {
+        if w > 0 {
+            fingerprint |= 1 << uint(i)
+        }
+    }
+    return fingerprint
+}
+
+
+// HammingDistance is the number of bits a and b differ in
+func HammingDistance( a, b uint64 ) int {
+    return bits.OnesCount64( a ^ b )
+}
+
+
+// ClusterSimHashes groups the fingerprints that are within distance bits of another fingerprint of the group,
+// returning the indexes of each group of two or more, in order.
+// Fingerprints are only compared to those that match them exactly on one of distance+1 blocks of bits, which
+// any two within distance do, so large crawls aren't compared pair by pair.
+func ClusterSimHashes( fingerprints []uint64, distance int ) [][]int {
+
+    if distance < 0 || len(fingerprints) < 2 {
+        return nil
+    }
+    if distance > 63 {
+        distance = 63
+    }
+
+    // Union-find of the fingerprints
+    parent := make( []int, len(fingerprints) )
+    for i := range parent {
+        parent[i] = i
+    }
+    var find func( i int ) int
+    find = func( i int ) int {
+        if parent[i] != i {
+            parent[i] = find( parent[i] )
+        }
+        return parent[i]
+    }
+
+    blocks := distance + 1
+    for b := 0; b < blocks; b++ {
+        lo, hi := b * 64 / blocks, (b+1) * 64 / blocks
+        mask := (^uint64(0) >> uint(64 - (hi - lo))) << uint(lo)
+        buckets := make( map[uint64][]int )
+        for i, f := range fingerprints {
+            buckets[f & mask] = append( buckets[f & mask], i )
+        }
+        for _, bucket := range buckets {
+            for x, i := range bucket {
+                for _, j := range bucket[x+1:] {
+                    if find(i) != find(j) && HammingDistance( fingerprints[i], fingerprints[j] ) <= distance {
+                        parent[find(j)] = find(i)
+                    }
+                }
+            }
+        }
+    }
+
+    groups := make( map[int][]int )
+    var roots []int
+    for i := range fingerprints {
+        root := find(i)
+        if _, ok := groups[root]; ok == false {
+            roots = append( roots, root )
+        }
+        groups[root] = append( groups[root], i )
+    }
+    var clusters [][]int
+    for _, root := range roots {
+        if len(groups[root]) > 1 {
+            clusters = append( clusters, groups[root] )
+        }
+    }
+    return clusters
+}
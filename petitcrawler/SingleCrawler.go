@@ -1,9 +1,15 @@
 package petitcrawler
 
 import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
     "fmt"
+    "net/http"
     "net/url"
     "os"
+    "path/filepath"
+    "sort"
     "errors"
     "time"
     "strings"
@@ -17,24 +23,81 @@ import (
 type SingleCrawler struct{
 
     Site *url.URL           // single site/ domain to be crawled
-    Sitemap [] Page         // a sitemap made of Pages
+    Storage Storage         // the frontier, visited URLs, and the sitemap made of Pages
+    StorageDir string       // option to keep the Storage on disk, in this directory
+    CheckpointFile string   // option to keep the Storage in this state file, and checkpoint the crawl to it
+    CheckpointEvery time.Duration  // time between checkpoints
+    resumed bool            // set by Resume, so Start keeps the Excluded, Failed and NonHTML URLs
+    stop chan time.Duration // Stop requests, with their grace period
     NumPages int            // number of pages collected - that are unique
     NumWorkers int          // number of workers to spawn 
     PRINT_LIMIT int         // for printing the site map, only display this many assets
     MAX_PAGES int           // max pages to crawl
     MAX_TIME time.Duration  // max time to crawl
+    MaxDepth int            // max links away from the start URL to crawl, negative for no limit
     Filename string         // option to output sitemap to a file
+    Output Output           // format the sitemap is written in, TextOutput by default
+    output Output           // the Output RunContext began, that pages are streamed to as they're collected
+    GraphCollapse int       // path segments pages are grouped by in link graphs, 0 for a node per page
+    Reason StopReason       // why the last crawl stopped
+
+    Client *http.Client             // client the workers fetch pages with
+    Transport http.RoundTripper     // option to use a custom transport
+    Timeout time.Duration           // timeout for a single request
+    TLSConfig *tls.Config           // option for a custom TLS config
+    RootCAs *x509.CertPool          // option for custom CAs, merged into the TLS config
+    MinTLSVersion uint16            // option for min TLS version, merged into the TLS config
+    ProxyURL *url.URL               // option to crawl through a proxy
+    MaxIdleConns int                // option to limit idle connections
+
+    UserAgent string                // User-Agent sent with requests, and matched against robots.txt
+    PageHeaders []string            // response headers recorded with each Page, nil for DEFAULT_PAGE_HEADERS
+    IgnoreRobots bool               // option to skip robots.txt, ex: for crawling your own site
+    Robots *Robots                  // the site's robots.txt rules, fetched by Start
+    HostRobots map[string]*Robots   // robots.txt rules of every host crawled, by scheme://host, filled in when the crawl stops
+    Excluded map[string]string      // URLs that were not crawled, and the reason why
+
+    RateLimit float64               // max requests per second to each host, 0 for no limit
+    Burst int                       // requests allowed at once before the rate limit applies
+    MinDelay time.Duration          // minimum time between requests to a host, raised to its robots.txt Crawl-delay
+    Retry *RetryPolicy              // which failed requests are retried, and how often
+    Failed []Fetch                  // URLs that could not be crawled, with every attempt made
+    NonHTML []Fetch                 // URLs that were fetched but aren't HTML pages, ex: PDFs
+    Normalizer *Normalizer          // rules for making URLs canonical before they are queued
+    ScopeMode ScopeMode             // which hosts are part of the site
+    AllowedHosts []string           // extra hosts to crawl with ScopeAllowList
+    IncludeRules []Rule             // if set, only URLs matching one of these are crawled
+    ExcludeRules []Rule             // URLs matching any of these are not crawled
+    Scope *Scope                    // decides which URLs are part of the site
+    Strategy Strategy               // order links are crawled in
+    Score ScoreFunc                 // scores links for BestFirst, nil for ScoreShortestPath
+    Dedup DedupMode                 // what makes two pages duplicates, only the first is kept
+    NearDupDistance int             // max bits apart the SimHash of near duplicate pages are, negative for no report
 
 }
 
 
+// StopReason describes why a crawl terminated
+type StopReason string
+
+const (
+    StopFrontierExhausted StopReason = "frontier exhausted"     // no URLs left to crawl and all workers idle
+    StopPageCap StopReason = "page cap reached"                 // collected MAX_PAGES pages
+    StopTimeCap StopReason = "time cap reached"                 // crawled for MAX_TIME
+    StopCancelled StopReason = "cancelled"                      // the context was cancelled or hit its deadline
+    StopStorageError StopReason = "storage error"               // the Storage failed to read or write
+    StopInterrupted StopReason = "interrupted"                  // Stop was called, ex: on SIGINT
+    StopOutputError StopReason = "output error"                 // the Output failed to write a page
+)
+
+
 // IsOk checks if a given crawler is okay to use 
 func IsOk(c *SingleCrawler) error {
     if c.Site == nil {
         return errors.New("Crawler has no Site.")
     }
-    if c.Sitemap == nil {
-        return errors.New("Crawler has no Sitemap.")
+    if c.Storage == nil {
+        return errors.New("Crawler has no Storage.")
     }
     if c.NumPages < 0 {
         return errors.New("Crawler has negative # of pages.")
@@ -45,50 +108,64 @@ func IsOk(c *SingleCrawler) error {
     if c.Filename == "" {
         return errors.New("Crawler has no Filename to write sitemap to.")
     }
+    if c.Output == nil {
+        return errors.New("Crawler has no Output format.")
+    }
+    if c.Client == nil {
+        return errors.New("Crawler has no http Client.")
+    }
+    if c.Normalizer == nil {
+        return errors.New("Crawler has no URL Normalizer.")
+    }
+    if c.Scope == nil {
+        return errors.New("Crawler has no Scope.")
+    }
     return nil
 }
 
 
-// NewCrawler creates a new SingleCrawler instance, initializing all fields, 
-// given a starting URL. 
-func New() (*SingleCrawler, error) {
+// NewSingleCrawler creates a new SingleCrawler instance, initializing all fields,
+// given a starting URL. Defaults can be changed by passing in Options.
+func NewSingleCrawler( startURL string, opts ...Option ) (*SingleCrawler, error) {
 
     defer glog.Flush()
 
     var crawler SingleCrawler
-    startURL := *UrlPtr
-    maxp := *MaxpPtr
-    maxc := *MaxcPtr
-    maxt := *MaxtPtr
-    Filename := *OutfilePtr
-    NumWorkers := *NumwPtr
+    crawler.stop = make( chan time.Duration, 1 )
+    crawler.PRINT_LIMIT = DEFAULT_PRINT_LIMIT
+    crawler.MAX_PAGES = DEFAULT_MAX_PAGES
+    crawler.MAX_TIME = DEFAULT_MAX_TIME
+    crawler.MaxDepth = DEFAULT_MAX_DEPTH
+    crawler.NearDupDistance = DEFAULT_NEAR_DUP_DISTANCE
+    crawler.NumWorkers = DEFAULT_NUM_WORKERS
+    crawler.UserAgent = DEFAULT_USER_AGENT
+    crawler.ScopeMode = ScopeWWW
+    crawler.RateLimit = DEFAULT_RATE_LIMIT
+    crawler.Burst = DEFAULT_BURST
+    policy := DEFAULT_RETRY_POLICY
+    crawler.Retry = &policy
+    normalizer := DEFAULT_NORMALIZER
+    crawler.Normalizer = &normalizer
+    crawler.Output = &TextOutput{}
+    crawler.NumPages = 0
 
     // validate the user input URL and decide if it's okay to use
     if govalidator.IsURL(startURL) == false {
         glog.Error("The starting URL is invalid. Please enter a valid URL.")
         return nil, errors.New("Bad starting URL.")
     }
-    if maxp < 0 || maxc < 0 || maxt < 0 {
-        glog.Error("Please pass in values > = 0 for max constraints (max print, max pages, max time). Please pass > 0 for the number of workers.")
-        return nil, errors.New("Bad values for maxprint, maxpages, maxtime, or NumWorkers")
-    }
-    if NumWorkers <= 0 || NumWorkers > MAX_WORKERS {
-        glog.Error("Number of workes is invalid. Must be > 0, and less that MAX_WORKERS.")
-        return nil, errors.New("Bad value for NumWorkers")
-    }
-    if len(Filename) >= 255 {
-        glog.Error("Filename can't be larger than 255 characters. Trimming Filename.")
-        Filename = Filename[0:100]
-    }
 
+    for _, opt := range opts {
+        if err := opt( &crawler ); err != nil {
+            glog.Error( fmt.Sprintf("Bad crawler option: %s", err) )
+            return nil, err
+        }
+    }
 
-    crawler.MAX_PAGES = maxc
-    crawler.PRINT_LIMIT = maxp
-    crawler.NumPages = 0
-    crawler.NumWorkers = NumWorkers
-    crawler.MAX_TIME = time.Duration(maxt) * time.Second
-    crawler.Sitemap = make( [] Page, crawler.MAX_PAGES)
-    
+    if err := buildClient( &crawler ); err != nil {
+        glog.Error( fmt.Sprintf("Unable to set up http client: %s", err) )
+        return nil, err
+    }
 
     // Parse the URL - make sure it's ok to use
     domain, err := url.Parse(startURL)
@@ -102,11 +179,39 @@ func New() (*SingleCrawler, error) {
         return nil, err
     }
     crawler.Site = domain
+
+    crawler.Scope, err = NewScope( crawler.ScopeMode, crawler.Site, crawler.AllowedHosts... )
+    if err != nil {
+        glog.Error( fmt.Sprintf("Unable to set up crawl scope: %s", err) )
+        return nil, err
+    }
+    crawler.Scope.Include = crawler.IncludeRules
+    crawler.Scope.Exclude = crawler.ExcludeRules
+
+    if crawler.Storage != nil && crawler.CheckpointFile != "" {
+        glog.Error("Can't use both a Storage and a checkpoint state file.")
+        return nil, errors.New("Can't use both a Storage and a checkpoint state file, the state file is the Storage.")
+    }
+    if crawler.Storage == nil {
+        if crawler.CheckpointFile != "" {
+            crawler.Storage, err = NewDiskStorage( crawler.CheckpointFile, crawler.Strategy, crawler.Score )
+            if err != nil {
+                glog.Error( fmt.Sprintf("Unable to set up state file: %s", err) )
+                return nil, err
+            }
+        } else if crawler.StorageDir == "" {
+            crawler.Storage = NewMemoryStorage( crawler.Strategy, crawler.Score )
+        } else {
+            crawler.Storage, err = NewDiskStorage( filepath.Join( crawler.StorageDir, crawler.Site.Host + ".db" ), crawler.Strategy, crawler.Score )
+            if err != nil {
+                glog.Error( fmt.Sprintf("Unable to set up storage: %s", err) )
+                return nil, err
+            }
+        }
+    }
     
-    if Filename != "" {
-        crawler.Filename = Filename
-    } else {
-        crawler.Filename = crawler.Site.Host + ".txt"
+    if crawler.Filename == "" && crawler.Output != nil {
+        crawler.Filename = crawler.Site.Host + crawler.Output.Extension()
         if len( crawler.Filename ) >= 255 {
             crawler.Filename = crawler.Filename[0:100]
         }
@@ -124,6 +229,14 @@ func New() (*SingleCrawler, error) {
 // Start begins the crawling process based on the starting url 
 // by passing urls in it's URL list to worker threads
 func ( crawler *SingleCrawler ) Start()(error) {
+    return crawler.StartContext( context.Background() )
+}
+
+
+// StartContext is Start, but also stops when ctx is cancelled or its deadline passes.
+// Workers are shut down, in-flight requests are aborted, and the pages collected so far
+// are left in the Sitemap. Returns ctx.Err() if the crawl was cut short by ctx.
+func ( crawler *SingleCrawler ) StartContext( ctx context.Context )(error) {
 
     defer glog.Flush()
 
@@ -131,89 +244,307 @@ func ( crawler *SingleCrawler ) Start()(error) {
         return err1
     }
 
+    if crawler.resumed == false || crawler.Excluded == nil {
+        crawler.Excluded = make( map[string]string )
+        crawler.Failed = nil
+        crawler.NonHTML = nil
+    }
+    crawler.resumed = false
+
+    // Each host has a limiter shared by all workers, so it sees the combined rate
+    limiters := NewHostLimiter( crawler.RateLimit, crawler.Burst, crawler.MinDelay )
+    fetcher := &Fetcher{ Client: crawler.Client, UserAgent: crawler.UserAgent, Limiters: limiters, Retry: crawler.Retry, Headers: crawler.PageHeaders }
+
+    // Workers check robots.txt before fetching, getting each host's the first time they need it,
+    // so the controller never waits on it. The site's is fetched before any pages are requested.
+    var robots *RobotsCache
+    if crawler.IgnoreRobots == false {
+        robots = NewRobotsCache( crawler.Client, crawler.UserAgent, limiters )
+        fetcher.Robots = robots
+        siteRobots, err := robots.Get( ctx, crawler.Site )
+        if err != nil {
+            crawler.Reason = StopCancelled
+            return err
+        }
+        crawler.Robots = siteRobots
+    }
+
     // Stats for termination conditions 
-    t0 := time.Now()            //Terminate after a given time
-    noIncrease := 0             //Make sure we are finding unique sites, and not in an inf loop 
-    last_pagecount := 0         //Keep track of the last # of pages
-    var wg sync.WaitGroup           //For termination, to wait on workers
+    t0 := time.Now()                        //Terminate after a given time
+    deadline := time.After(crawler.MAX_TIME)
+    pending := 0                            //URLs sent to workers that they haven't finished yet
+    var wg sync.WaitGroup                   //For termination, to wait on workers
 
     // Channels for communication to workers
     pages := make( chan Page, crawler.NumWorkers*10 )
-    rurls := make( chan string, crawler.NumWorkers*10 )
-    surls := make( chan string, crawler.NumWorkers*10 )
+    rurls := make( chan Link, crawler.NumWorkers*10 )
+    surls := make( chan Link )
+    done := make( chan Fetch, crawler.NumWorkers*10 )
     shutdown := make( chan bool, crawler.NumWorkers )
 
-    // Map for making pages and urls unique
-    assets := make( map[string][]string )
-    vList := make( map[string]int )
+    // Links wait in the Storage's frontier, and are handed to workers one at a time as they become free,
+    // so the frontier's order is the order they are crawled in. Pages and urls are made unique with its sets.
+    store := crawler.Storage
+ 
+    // Checks if link is in scope, recording it as excluded under key if not
+    allowed := func( link string, key string ) bool {
+        u, err := url.Parse( link )
+        if err != nil {
+            return false
+        }
+        if ok, reason := crawler.Scope.Allowed( u ); ok == false {
+            glog.Info( fmt.Sprintf("%s is not allowed (%s), skipping", link, reason) )
+            crawler.Excluded[key] = reason
+            return false
+        }
+        return true
+    }
  
     // Start the crawling, by providing the inital site URL
-    surls <- crawler.Site.String()
-    vList[crawler.Site.String()]++
+    site := *crawler.Site
+    if site.Path == "" {
+        site.Path = "/"
+    }
+    start := crawler.Normalizer.NormalizeURL( crawler.Site )
+    added, err := store.Mark( SetVisited, start )
+    if err == nil && added && allowed( site.String(), start ) {
+        err = store.Push( Link{ URL: site.String() } )
+    }
+    if err != nil {
+        glog.Error( fmt.Sprintf("Storage failed: %s", err) )
+        crawler.Reason = StopStorageError
+        return err
+    }
     
 
-    // Spawn the requested number of workers for the program
+    // Spawn the requested number of workers for the program.
+    // Their requests are aborted when the crawl finishes, so it doesn't wait on slow pages.
+    workCtx, cancelWork := context.WithCancel( ctx )
+    defer cancelWork()
     for i:= 0; i< crawler.NumWorkers; i++ {
         wg.Add(1)
-        go Worker( i, surls, rurls, crawler.Site, pages, shutdown, &wg )
+        go Worker( workCtx, i, fetcher, surls, rurls, crawler.Scope, pages, done, shutdown, &wg )
+    }
+
+    stopping := false                       //Stop was called, no new URLs are sent to workers
+    var grace <-chan time.Time              //when in-flight URLs have had long enough to finish
+
+    // Tell workers to quit, wait for them, and close all channels
+    finish := func( reason StopReason ) {
+        crawler.Reason = reason
+        glog.Info( fmt.Sprintf("Terminating crawler: %s", reason) )
+        glog.Info("Total time spent crawling is ", time.Since(t0))
+        fmt.Printf("Status Update. Pages collected %d. Visited %d. Queued %d. Stopped: %s.\n", crawler.NumPages, store.Count(SetVisited), store.Queued(), reason)
+        fmt.Println("Total time: ", time.Since(t0))
+
+        cancelWork()
+        for i:= 0; i< crawler.NumWorkers; i++ {
+            shutdown <- true
+        }
+        wg.Wait()
+        crawler.HostRobots = nil
+        if robots != nil {
+            crawler.HostRobots = robots.All()
+        }
+
+        close(rurls)
+        close(surls)
+        close(done)
+        close(shutdown)
+        close(pages)
+
+        if err := crawler.addReferrers(); err != nil {
+            glog.Error( fmt.Sprintf("Unable to find referrers of failed URLs: %s", err) )
+        }
+
+        // Links the workers were still on stay in flight, to be crawled again on resume
+        if err := crawler.checkpoint(); err != nil {
+            glog.Error( fmt.Sprintf("Unable to checkpoint crawl: %s", err) )
+        }
+        fmt.Print("Done\n\n\n")
+    }
+
+    // Receive a link to crawl, normalize it, make sure it's unvisited, allowed and not too deep, then queue it.
+    // The normalized URL only says if the link was seen, the link is fetched as it was found (without its #fragment).
+    queue := func( found Link ) error {
+        link, err := crawler.Normalizer.Normalize( found.URL )
+        if err != nil {
+            return nil
+        }
+        u, err := url.Parse( found.URL )
+        if err != nil {
+            return nil
+        }
+        u.Fragment = ""
+        // Too deep links aren't marked visited, they may be found again closer to the start
+        if crawler.MaxDepth >= 0 && found.Depth > crawler.MaxDepth {
+            seen, err := store.Seen( SetVisited, link )
+            if err == nil && seen == false {
+                crawler.Excluded[link] = "max depth"
+            }
+            return err
+        }
+        added, err := store.Mark( SetVisited, link )
+        if err != nil || added == false {
+            return err
+        }
+        delete( crawler.Excluded, link )
+        if allowed( u.String(), link ) == false {
+            return nil
+        }
+        glog.Info( fmt.Sprintf("queueing %s\n", u))
+        return store.Push( Link{ URL: u.String(), Depth: found.Depth, Parent: found.Parent } )
+    }
+
+    // Stop the crawl when the Storage fails, there's no safe way to continue
+    storageFailed := func( err error ) error {
+        glog.Error( fmt.Sprintf("Storage failed: %s", err) )
+        finish( StopStorageError )
+        return err
+    }
+
+    if crawler.NumPages >= crawler.MAX_PAGES {
+        finish( StopPageCap )
+        return nil
+    }
+
+    // Checkpoint periodically if the Storage can, a nil channel never fires
+    var checkpoints <-chan time.Time
+    if _, ok := store.(Checkpointer); ok && crawler.CheckpointEvery > 0 {
+        ticker := time.NewTicker( crawler.CheckpointEvery )
+        defer ticker.Stop()
+        checkpoints = ticker.C
     }
 
 
     for {
 
+        // Workers send their links and pages before reporting done, so once nothing is pending 
+        // and those channels are drained there is nothing left to crawl.
+        idle := pending == 0 && len(rurls) == 0 && len(pages) == 0 && len(done) == 0
+        if idle && stopping {
+            finish( StopInterrupted )
+            return nil
+        }
+        if idle && store.Queued() == 0 {
+            finish( StopFrontierExhausted )
+            return nil
+        }
+
+        // Only offer a link to the workers when there is one, and not stopping. A nil channel is never ready
+        var next Link
+        var send chan Link
+        if stopping == false {
+            link, ok, err := store.Peek()
+            if err != nil {
+                return storageFailed( err )
+            }
+            if ok {
+                next = link
+                send = surls
+            }
+        }
+
         select { 
 
-            case link := <- rurls:
-                // Receive a link to crawl, make sure it's unvisited, then send back
-                if _, ok := vList[link]; ok == false {
-                    glog.Info( fmt.Sprintf("starting crawler for %s\n", link))
-                    surls <- link
-                    vList[link]++
-                } 
+            case send <- next:
+                if _, _, err := store.Pop(); err != nil {
+                    return storageFailed( err )
+                }
+                pending++
+
+            case <- ctx.Done():
+                finish( StopCancelled )
+                return ctx.Err()
+
+            case <- deadline:
+                finish( StopTimeCap )
+                return nil
+
+            case d := <- crawler.stop:
+                // Let the URLs being crawled finish, for up to the grace period
+                glog.Info( fmt.Sprintf("Stopping crawler, waiting up to %s for %d URLs being crawled", d, pending) )
+                stopping = true
+                grace = time.After( d )
+
+            case <- grace:
+                finish( StopInterrupted )
+                return nil
+
+            case <- checkpoints:
+                if err := crawler.checkpoint(); err != nil {
+                    return storageFailed( err )
+                }
+
+            case found := <- rurls:
+                if err := queue( found ); err != nil {
+                    return storageFailed( err )
+                }
 
             case p := <- pages:
-                //receive a page in the page channel, append it to the crawler's sitemap, if it's unique.
-                ind := strings.Join(p.Assets, " ")
-                if crawler.NumPages < len(crawler.Sitemap){
-                    if _, ok := assets[ind]; ok == false {
-                        assets[ind] = p.BabyUrls
-                        crawler.Sitemap[crawler.NumPages] = p
-                        crawler.NumPages += 1
+                // A page's links are sent before it, queue them before the page is stored and its link is done
+                for len(rurls) > 0 {
+                    if err := queue( <- rurls ); err != nil {
+                        return storageFailed( err )
                     }
                 }
-            default:
-                // Print status update. 
-                // Check termination conditions: time, space, nonincreasing, no urls left
-                if time.Since(t0) % 1000000 == 0 {
-                    if crawler.NumPages == last_pagecount {
-                        noIncrease +=1
+
+                // Record the links the page's rules rejected
+                for link, reason := range p.Rejected {
+                    if key, err := crawler.Normalizer.Normalize( link ); err == nil {
+                        crawler.Excluded[key] = reason
                     }
-                    last_pagecount = crawler.NumPages
                 }
 
-                if noIncrease > 7 || time.Since(t0) >= crawler.MAX_TIME || crawler.NumPages >= crawler.MAX_PAGES {
-
-                    glog.Info("Terminating crawler on a specified condition (time/no URLs left to crawl/ reached max)")
-                    glog.Info("Total time spent crawling is ", time.Since(t0))
-                    fmt.Printf("Status Update. Pages collected %d. Visited %d.\n", crawler.NumPages, len(vList))
-                    fmt.Println("Total time: ", time.Since(t0))
-
-                    // Tell workers to quit
-                    for i:= 0; i< crawler.NumWorkers; i++ {
-                        shutdown <- true
+                //receive a page in the page channel, append it to the crawler's sitemap, if it's unique.
+                //A duplicate is recorded as an alias of the page kept.
+                //The page's link is done once the page is stored, even if the worker is stopped before it says so.
+                if crawler.NumPages < crawler.MAX_PAGES {
+                    key := dedupKey( crawler.Dedup, p, crawler.Normalizer )
+                    canonical, found, err := store.Get( SetPages, key )
+                    if err == nil && found == false {
+                        err = store.Put( SetPages, key, p.MyUrl )
+                        if err == nil {
+                            err = store.AddPage( p )
+                            crawler.NumPages += 1
+                        }
+                        if err == nil && crawler.output != nil {
+                            if err = crawler.output.Page( crawler, p ); err != nil {
+                                glog.Error( fmt.Sprintf("Output failed: %s", err) )
+                                finish( StopOutputError )
+                                return err
+                            }
+                        }
+                    } else if err == nil && canonical != p.MyUrl {
+                        err = crawler.addAlias( canonical, p.MyUrl )
                     }
-
-                    // Wait for workers to quit
-                    wg.Wait()
-
-                    // Close all channels
-                    close(rurls)
-                    close(surls)
-                    close(shutdown)
-                    close(pages)
-                    fmt.Println("Done\n\n")
+                    if err == nil {
+                        err = store.Done( p.MyUrl )
+                    }
+                    if err != nil {
+                        return storageFailed( err )
+                    }
+                }
+                if crawler.NumPages >= crawler.MAX_PAGES {
+                    finish( StopPageCap )
                     return nil
                 }
+
+            case f := <- done:
+                // A worker finished a URL. Keep track of the ones that failed, or weren't pages.
+                pending--
+                if f.Err != "" {
+                    crawler.Failed = append( crawler.Failed, f )
+                } else if f.NotHTML {
+                    crawler.NonHTML = append( crawler.NonHTML, f )
+                } else if f.Disallowed {
+                    if key, err := crawler.Normalizer.Normalize( f.URL ); err == nil {
+                        crawler.Excluded[key] = "robots.txt"
+                    }
+                }
+                if err := store.Done( f.URL ); err != nil {
+                    return storageFailed( err )
+                }
         }
     }
 }
@@ -238,9 +569,76 @@ func ( crawler *SingleCrawler ) Print() error {
         os.Stdout = outfile
     }
 
-    fmt.Printf("SiteMap from starting URL %s, total pages found %d.\n\n\n", crawler.Site.String(), crawler.NumPages )
-    for i := 0; i < crawler.NumPages; i++ {
-        crawler.Sitemap[i].Print(crawler.PRINT_LIMIT)
+    fmt.Printf("SiteMap from starting URL %s, total pages found %d.\n", crawler.Site.String(), crawler.NumPages )
+    fmt.Printf("Crawl stopped: %s.\n", crawler.Reason )
+    levels := make( []int, 0 )
+    err = crawler.Storage.Pages( func( p Page ) error {
+        for len(levels) <= p.Depth {
+            levels = append( levels, 0 )
+        }
+        levels[p.Depth]++
+        return nil
+    })
+    for depth, n := range levels {
+        fmt.Printf("\tDepth %d: %d pages\n", depth, n)
+    }
+    fmt.Print("\n\n")
+    if err == nil {
+        err = crawler.EachPage( func( p Page ) error {
+            p.Print(crawler.PRINT_LIMIT)
+            return nil
+        })
+    }
+
+    if err == nil {
+        var clusters [][]string
+        clusters, err = crawler.NearDuplicates()
+        if len(clusters) > 0 {
+            fmt.Printf("Near duplicate pages (%d clusters, within %d bits):\n", len(clusters), crawler.NearDupDistance)
+            for _, cluster := range clusters {
+                fmt.Printf("\t%s\n", strings.Join( cluster, "\n\t\t" ))
+            }
+            fmt.Print("\n\n")
+        }
+    }
+
+    if len(crawler.Excluded) > 0 {
+        excluded := make( []string, 0, len(crawler.Excluded) )
+        for link := range crawler.Excluded {
+            excluded = append( excluded, link )
+        }
+        sort.Strings(excluded)
+        fmt.Printf("Excluded URLs (%d):\n", len(excluded))
+        for _, link := range excluded {
+            fmt.Printf("\t%s (%s)\n", link, crawler.Excluded[link])
+        }
+        fmt.Print("\n\n")
+    }
+
+    if len(crawler.Failed) > 0 {
+        fmt.Printf("Failed URLs (%d):\n", len(crawler.Failed))
+        for _, f := range crawler.Failed {
+            kind := "permanent"
+            if f.Transient {
+                kind = "transient"
+            }
+            fmt.Printf("\t%s (%s, %d attempts): %s\n", f.URL, kind, len(f.Attempts), f.Err)
+            if len(f.Referrers) > 0 {
+                fmt.Printf("\t\tlinked from %s\n", strings.Join( f.Referrers, ", " ))
+            }
+        }
+        fmt.Print("\n\n")
+    }
+
+    if len(crawler.NonHTML) > 0 {
+        fmt.Printf("Non-HTML URLs (%d):\n", len(crawler.NonHTML))
+        for _, f := range crawler.NonHTML {
+            fmt.Printf("\t%s (%s)\n", f.URL, f.ContentType)
+            if len(f.Referrers) > 0 {
+                fmt.Printf("\t\tlinked from %s\n", strings.Join( f.Referrers, ", " ))
+            }
+        }
+        fmt.Print("\n\n")
     }
 
     if duped == true {
@@ -248,13 +646,142 @@ func ( crawler *SingleCrawler ) Print() error {
         os.Stdout = stdout
     }
 
-    return nil
+    if err != nil {
+        glog.Error( fmt.Sprintf("Unable to read pages from storage: %s", err) )
+    }
+    return err
+
+}
+
+
+// EachPage calls fn on every collected page, in the order they were collected, with the URLs of its duplicates
+func ( crawler *SingleCrawler ) EachPage( fn func(Page) error ) error {
+
+    return crawler.Storage.Pages( func( p Page ) error {
+        aliases, found, err := crawler.Storage.Get( SetAliases, p.MyUrl )
+        if err != nil {
+            return err
+        }
+        if found {
+            p.Aliases = strings.Split( aliases, "\n" )
+        }
+        return fn( p )
+    })
+}
 
+
+// NearDuplicates returns the URLs of the collected pages in clusters of near duplicates, pages whose
+// SimHash is at most NearDupDistance bits from another page of the cluster. Pages are in the order collected.
+func ( crawler *SingleCrawler ) NearDuplicates() ([][]string, error) {
+
+    if crawler.NearDupDistance < 0 {
+        return nil, nil
+    }
+    var urls []string
+    var fingerprints []uint64
+    err := crawler.Storage.Pages( func( p Page ) error {
+        urls = append( urls, p.MyUrl )
+        fingerprints = append( fingerprints, p.SimHash )
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var clusters [][]string
+    for _, cluster := range ClusterSimHashes( fingerprints, crawler.NearDupDistance ) {
+        pages := make( []string, len(cluster) )
+        for i, n := range cluster {
+            pages[i] = urls[n]
+        }
+        clusters = append( clusters, pages )
+    }
+    return clusters, nil
+}
+
+
+// addReferrers adds the collected pages that link to each failed or non-HTML URL to its Referrers
+func ( crawler *SingleCrawler ) addReferrers() error {
+
+    outcomes := make( map[string]*Fetch )
+    for _, fetches := range [][]Fetch{ crawler.Failed, crawler.NonHTML } {
+        for i := range fetches {
+            if key, err := crawler.Normalizer.Normalize( fetches[i].URL ); err == nil {
+                outcomes[key] = &fetches[i]
+            }
+        }
+    }
+    if len(outcomes) == 0 {
+        return nil
+    }
+    return crawler.Storage.Pages( func( p Page ) error {
+        for _, link := range p.BabyUrls {
+            key, err := crawler.Normalizer.Normalize( link )
+            if err != nil || outcomes[key] == nil {
+                continue
+            }
+            f := outcomes[key]
+            found := false
+            for _, referrer := range f.Referrers {
+                found = found || referrer == p.MyUrl
+            }
+            if found == false {
+                f.Referrers = append( f.Referrers, p.MyUrl )
+            }
+        }
+        return nil
+    })
+}
+
+
+// addAlias records link as a duplicate of the collected page at canonical
+func ( crawler *SingleCrawler ) addAlias( canonical, link string ) error {
+
+    aliases, found, err := crawler.Storage.Get( SetAliases, canonical )
+    if err != nil {
+        return err
+    }
+    if found {
+        aliases += "\n"
+    }
+    glog.Info( fmt.Sprintf("%s is a duplicate of %s", link, canonical) )
+    return crawler.Storage.Put( SetAliases, canonical, aliases + link )
+}
+
+
+// Stop asks a running crawl to stop: no new URLs are sent to the workers, and the ones being
+// crawled have up to grace to finish before they are aborted. The crawl then ends with StopInterrupted,
+// keeping the pages collected. Safe to call from another goroutine, ex: a signal handler.
+// A Stop while the crawl is still starting up applies as soon as it's running.
+func ( crawler *SingleCrawler ) Stop( grace time.Duration ) {
+
+    select {
+        case crawler.stop <- grace:
+        default:
+    }
+}
+
+
+// Close releases the crawler's Storage, the crawler can't be used after
+func ( crawler *SingleCrawler ) Close() error {
+
+    if crawler.Storage == nil {
+        return nil
+    }
+    return crawler.Storage.Close()
 }
 
 
 // Runs the crawler
 func (mycrawler *SingleCrawler) Run() (error) {
+    return mycrawler.RunContext( context.Background() )
+}
+
+
+// RunContext runs the crawler until it finishes or ctx is done.
+// The sitemap collected so far is always written by the crawler's Output, and ctx.Err() is returned 
+// if the crawl was cut short by ctx.
+func (mycrawler *SingleCrawler) RunContext( ctx context.Context ) (error) {
 
     if err := IsOk(mycrawler); err != nil{
         return err
@@ -265,19 +792,26 @@ func (mycrawler *SingleCrawler) Run() (error) {
     // Start the crawler
     glog.Info("Starting web crawler")
     fmt.Println("starting web crawler")
-    mycrawler.Start()
+    if err := mycrawler.Output.Begin( mycrawler ); err != nil {
+        glog.Error( fmt.Sprintf("Unable to start writing sitemap: %s", err) )
+        return errors.New("Unable to write sitemap")
+    }
+    mycrawler.output = mycrawler.Output
+    crawlErr := mycrawler.StartContext( ctx )
+    mycrawler.output = nil
 
-    // When done, print out the site map
-    glog.Info("Done crawling, printing Sitemap")
-    err := mycrawler.Print()
+    // When done, write out the site map
+    glog.Info("Done crawling, writing Sitemap")
+    err := mycrawler.Output.End( mycrawler )
     if err!= nil{
+        glog.Error( fmt.Sprintf("Unable to write sitemap: %s", err) )
         return errors.New("Unable to print sitemap")
     }
 
     // Log info when done, including elapsed time
     elapsed := time.Since(start)
     glog.Info( fmt.Sprintf("Finished Crawling Site, total elapsed time is %s", elapsed))
-    fmt.Sprintf("Finished Crawling Site, total elapsed time is %s\n\n", elapsed)
-    return nil
+    fmt.Printf("Finished Crawling Site, total elapsed time is %s\n\n", elapsed)
+    return crawlErr
 
 }
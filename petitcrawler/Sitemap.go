@@ -0,0 +1,283 @@
+package petitcrawler
+
+
+import (
+    "bufio"
+    "compress/gzip"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/golang/glog"
+)
+
+
+// Limits of one sitemap file, from the sitemaps.org protocol
+const SITEMAP_MAX_URLS = 50000
+const SITEMAP_MAX_BYTES = 50 * 1024 * 1024     // uncompressed
+const SITEMAP_MAX_URL_LENGTH = 2048
+
+const sitemapHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+const sitemapFooter = "</urlset>\n"
+const sitemapIndexHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+const sitemapIndexFooter = "</sitemapindex>\n"
+
+// Values <changefreq> can take
+var SITEMAP_CHANGE_FREQS = []string{ "always", "hourly", "daily", "weekly", "monthly", "yearly", "never" }
+
+
+// SitemapOptions configures WriteSitemap
+type SitemapOptions struct {
+
+    BaseURL string          // URL the sitemap files are served from, for the <loc>s of a sitemap index. Empty means the site's root
+    ChangeFreq string       // <changefreq> of every URL, empty to leave it out
+    Priority float64        // <priority> of every URL, 0.0 to 1.0 (clamped to that range)
+    HasPriority bool        // write Priority, even if it's 0. False leaves <priority> out
+    Gzip bool               // gzip the files, adding .gz to their names
+    MaxURLs int             // URLs per file, 0 means SITEMAP_MAX_URLS
+    MaxBytes int            // bytes per file before gzip, 0 means SITEMAP_MAX_BYTES
+
+}
+
+
+// WriteSitemap writes the collected pages as an XML sitemap (sitemaps.org) to path, at their URL after redirects,
+// with <lastmod> from their Last-Modified header. That header is only recorded if it's one of the crawler's
+// PageHeaders, as it is by default. Pages that name another URL as canonical are left out.
+// Pages redirected to the same URL are listed once, as the crawl keeps one of them in DedupURL mode.
+// When the pages don't fit in one file they are split into path-1.xml, path-2.xml, ... and path is a
+// sitemap index of them. Returns the files written, the one at path last.
+func ( crawler *SingleCrawler ) WriteSitemap( path string, opts SitemapOptions ) ([]string, error) {
+
+    known := opts.ChangeFreq == ""
+    for _, freq := range SITEMAP_CHANGE_FREQS {
+        known = known || freq == opts.ChangeFreq
+    }
+    if known == false {
+        return nil, errors.New( fmt.Sprintf("Unknown sitemap change frequency %s, must be one of %s.", opts.ChangeFreq, SITEMAP_CHANGE_FREQS))
+    }
+    opts.Priority = math.Max( 0, math.Min( 1, opts.Priority ) )
+    if opts.MaxURLs <= 0 || opts.MaxURLs > SITEMAP_MAX_URLS {
+        opts.MaxURLs = SITEMAP_MAX_URLS
+    }
+    if opts.MaxBytes <= 0 || opts.MaxBytes > SITEMAP_MAX_BYTES {
+        opts.MaxBytes = SITEMAP_MAX_BYTES
+    }
+    if opts.BaseURL == "" {
+        opts.BaseURL = crawler.Site.Scheme + "://" + crawler.Site.Host + "/"
+    }
+    headers := crawler.PageHeaders
+    if headers == nil {
+        headers = DEFAULT_PAGE_HEADERS
+    }
+    lastModified := false
+    for _, name := range headers {
+        lastModified = lastModified || http.CanonicalHeaderKey(name) == "Last-Modified"
+    }
+    if lastModified == false {
+        glog.Warning("Last-Modified isn't one of the page headers recorded, the sitemap will have no <lastmod>.")
+    }
+    if opts.Gzip && strings.HasSuffix( path, ".gz" ) == false {
+        path += ".gz"
+    }
+    stem := strings.TrimSuffix( strings.TrimSuffix( path, ".gz" ), ".xml" )
+    partPath := func( n int ) string {
+        part := fmt.Sprintf( "%s-%d.xml", stem, n )
+        if opts.Gzip {
+            part += ".gz"
+        }
+        return part
+    }
+
+    // Write the URLs to parts, starting a new one when one is full
+    var parts []string
+    var part *sitemapFile
+    err := crawler.Storage.Pages( func( p Page ) error {
+        entry, ok := sitemapEntry( p, opts, crawler.Normalizer )
+        if ok == false {
+            return nil
+        }
+        if part != nil && ( part.urls >= opts.MaxURLs || part.size + len(entry) + len(sitemapFooter) > opts.MaxBytes ) {
+            err := part.close( sitemapFooter )
+            part = nil
+            if err != nil {
+                return err
+            }
+        }
+        if part == nil {
+            var err error
+            if part, err = createSitemapFile( partPath( len(parts) + 1 ), opts.Gzip, sitemapHeader ); err != nil {
+                return err
+            }
+            parts = append( parts, part.path )
+        }
+        part.urls++
+        return part.write( entry )
+    })
+    if part != nil {
+        if cerr := part.close( sitemapFooter ); err == nil {
+            err = cerr
+        }
+    }
+    if err != nil {
+        return parts, errors.New( fmt.Sprintf("Unable to write sitemap %s. Error is %s.", path, err))
+    }
+
+    // One file (or none) is the sitemap itself, more need an index
+    if len(parts) <= 1 {
+        if len(parts) == 1 {
+            err = os.Rename( parts[0], path )
+        } else {
+            part, err = createSitemapFile( path, opts.Gzip, sitemapHeader )
+            if err == nil {
+                err = part.close( sitemapFooter )
+            }
+        }
+        if err != nil {
+            return nil, errors.New( fmt.Sprintf("Unable to write sitemap %s. Error is %s.", path, err))
+        }
+        return []string{ path }, nil
+    }
+
+    if len(parts) > SITEMAP_MAX_URLS {
+        return parts, errors.New( fmt.Sprintf("Sitemap %s needs %d files, more than an index can hold.", path, len(parts)))
+    }
+    index, err := createSitemapFile( path, opts.Gzip, sitemapIndexHeader )
+    if err == nil {
+        now := time.Now().UTC().Format( time.RFC3339 )
+        base := strings.TrimSuffix( opts.BaseURL, "/" ) + "/"
+        for _, p := range parts {
+            if err = index.write( fmt.Sprintf( "  <sitemap>\n    <loc>%s</loc>\n    <lastmod>%s</lastmod>\n  </sitemap>\n", escapeXML( base + filepath.Base(p) ), now ) ); err != nil {
+                break
+            }
+        }
+        if cerr := index.close( sitemapIndexFooter ); err == nil {
+            err = cerr
+        }
+    }
+    if err != nil {
+        return parts, errors.New( fmt.Sprintf("Unable to write sitemap index %s. Error is %s.", path, err))
+    }
+    glog.Info( fmt.Sprintf("Wrote sitemap index %s of %d sitemaps", path, len(parts)) )
+    return append( parts, path ), nil
+}
+
+
+// sitemapLoc is the URL page is listed at in the sitemap, where it was found after redirects
+func sitemapLoc( page Page ) string {
+    if page.FinalURL != "" {
+        return page.FinalURL
+    }
+    return page.MyUrl
+}
+
+
+// sitemapEntry is the <url> element of page, false if page doesn't belong in the sitemap.
+// URLs are compared normalized with normalizer, to tell if the page is its own canonical URL.
+func sitemapEntry( page Page, opts SitemapOptions, normalizer *Normalizer ) (string, bool) {
+
+    loc := sitemapLoc( page )
+    if page.Canonical != "" {
+        canonical, err := normalizer.Normalize( page.Canonical )
+        if err != nil {
+            canonical = page.Canonical
+        }
+        self := false
+        for _, link := range []string{ page.MyUrl, page.FinalURL } {
+            if key, err := normalizer.Normalize( link ); err == nil {
+                self = self || key == canonical
+            }
+        }
+        if self == false {
+            return "", false
+        }
+    }
+    if len( loc ) >= SITEMAP_MAX_URL_LENGTH {
+        glog.Warning( fmt.Sprintf("Leaving %s out of the sitemap, its URL is too long.", loc) )
+        return "", false
+    }
+
+    var entry strings.Builder
+    entry.WriteString( "  <url>\n    <loc>" + escapeXML( loc ) + "</loc>\n" )
+    if modified, err := http.ParseTime( page.Headers["Last-Modified"] ); err == nil {
+        entry.WriteString( "    <lastmod>" + modified.UTC().Format( time.RFC3339 ) + "</lastmod>\n" )
+    }
+    if opts.ChangeFreq != "" {
+        entry.WriteString( "    <changefreq>" + opts.ChangeFreq + "</changefreq>\n" )
+    }
+    if opts.HasPriority {
+        entry.WriteString( "    <priority>" + strconv.FormatFloat( opts.Priority, 'f', -1, 64 ) + "</priority>\n" )
+    }
+    entry.WriteString( "  </url>\n" )
+    return entry.String(), true
+}
+
+
+// escapeXML escapes s for the text of an XML element
+func escapeXML( s string ) string {
+    var b strings.Builder
+    xml.EscapeText( &b, []byte(s) )
+    return b.String()
+}
+
+
+// sitemapFile is one sitemap file being written, maybe gzipped, counting what's written to it
+type sitemapFile struct {
+    path string
+    file *os.File
+    gz *gzip.Writer
+    w *bufio.Writer
+    urls int
+    size int               // bytes written, before gzip
+}
+
+
+// createSitemapFile creates the file at path, starting it with header
+func createSitemapFile( path string, gz bool, header string ) (*sitemapFile, error) {
+
+    file, err := os.Create( path )
+    if err != nil {
+        return nil, err
+    }
+    sf := &sitemapFile{ path: path, file: file }
+    if gz {
+        sf.gz = gzip.NewWriter( file )
+        sf.w = bufio.NewWriter( sf.gz )
+    } else {
+        sf.w = bufio.NewWriter( file )
+    }
+    if err = sf.write( header ); err != nil {
+        file.Close()
+        return nil, err
+    }
+    return sf, nil
+}
+
+
+func ( sf *sitemapFile ) write( s string ) error {
+    sf.size += len(s)
+    _, err := sf.w.WriteString( s )
+    return err
+}
+
+
+// close ends the file with footer, and closes it
+func ( sf *sitemapFile ) close( footer string ) error {
+
+    err := sf.write( footer )
+    if err == nil {
+        err = sf.w.Flush()
+    }
+    if err == nil && sf.gz != nil {
+        err = sf.gz.Close()
+    }
+    if cerr := sf.file.Close(); err == nil {
+        err = cerr
+    }
+    return err
+}
@@ -0,0 +1,165 @@
+package petitcrawler
+
+
+// Names of the sets of keys the crawler keeps in its Storage
+const (
+    SetVisited = "visited"      // normalized URLs already queued or crawled
+    SetPages = "pages"          // dedup keys of collected pages (URL or content hash), and the URL of the page kept for each
+    SetAliases = "aliases"      // URLs of collected pages, and the newline separated URLs of their duplicates
+)
+
+
+// Storage holds the state of a crawl: the frontier of links waiting to be crawled,
+// the links being crawled, sets of keys already seen (ex: visited URLs), and the collected pages.
+// It is only used by the controller loop, so it doesn't need to be safe for concurrent use.
+type Storage interface {
+
+    Push( link Link ) error                 // add a link to the frontier
+    Peek() (Link, bool, error)              // the link Pop would return, false if the frontier is empty
+    Pop() (Link, bool, error)               // remove and return the next link, it's in flight until Done. false if the frontier is empty
+    Queued() int                            // number of links in the frontier
+    Done( url string ) error                // the in-flight link for url was crawled, or failed
+    Requeue() (int, error)                  // put links still in flight back in the frontier, ex: after a crash
+
+    Mark( set, key string ) (bool, error)   // add key to set, false if it was already there
+    Seen( set, key string ) (bool, error)   // reports if key is in set
+    Get( set, key string ) (string, bool, error)    // the value of key in set, false if it isn't there
+    Put( set, key, value string ) error     // add key to set with a value, replacing any value it had
+    Count( set string ) int                 // number of keys in set
+
+    AddPage( page Page ) error              // record a collected page
+    Pages( fn func(Page) error ) error      // call fn on every page, in the order they were added, stopping on an error
+
+    Close() error
+
+}
+
+
+// Checkpointer is a Storage that can also keep the crawler's own state, so an interrupted crawl can be resumed
+type Checkpointer interface {
+
+    Checkpoint( state []byte ) error        // save state, and make everything stored so far durable
+    LoadCheckpoint() ([]byte, error)        // the last state saved, nil if there is none
+
+}
+
+
+// MemoryStorage keeps the crawl state in memory, the default Storage
+type MemoryStorage struct {
+
+    frontier *Frontier
+    inflight map[string]Link
+    sets map[string]map[string]string
+    pages []Page
+
+}
+
+
+// NewMemoryStorage creates an empty MemoryStorage, handing out links by strategy, scored by score for BestFirst
+func NewMemoryStorage( strategy Strategy, score ScoreFunc ) *MemoryStorage {
+    return &MemoryStorage{ frontier: NewFrontier( strategy, score ), inflight: make( map[string]Link ), sets: make( map[string]map[string]string ) }
+}
+
+
+func ( store *MemoryStorage ) Push( link Link ) error {
+    store.frontier.Push( link )
+    return nil
+}
+
+
+func ( store *MemoryStorage ) Peek() (Link, bool, error) {
+    link, ok := store.frontier.Peek()
+    return link, ok, nil
+}
+
+
+func ( store *MemoryStorage ) Pop() (Link, bool, error) {
+
+    link, ok := store.frontier.Pop()
+    if ok {
+        store.inflight[link.URL] = link
+    }
+    return link, ok, nil
+}
+
+
+func ( store *MemoryStorage ) Queued() int {
+    return store.frontier.Len()
+}
+
+
+func ( store *MemoryStorage ) Done( url string ) error {
+    delete( store.inflight, url )
+    return nil
+}
+
+
+func ( store *MemoryStorage ) Requeue() (int, error) {
+
+    n := len( store.inflight )
+    for url, link := range store.inflight {
+        store.frontier.Push( link )
+        delete( store.inflight, url )
+    }
+    return n, nil
+}
+
+
+func ( store *MemoryStorage ) Mark( set, key string ) (bool, error) {
+
+    if seen, _ := store.Seen( set, key ); seen {
+        return false, nil
+    }
+    return true, store.Put( set, key, "" )
+}
+
+
+func ( store *MemoryStorage ) Seen( set, key string ) (bool, error) {
+    _, ok := store.sets[set][key]
+    return ok, nil
+}
+
+
+func ( store *MemoryStorage ) Get( set, key string ) (string, bool, error) {
+    value, ok := store.sets[set][key]
+    return value, ok, nil
+}
+
+
+func ( store *MemoryStorage ) Put( set, key, value string ) error {
+
+    keys, ok := store.sets[set]
+    if ok == false {
+        keys = make( map[string]string )
+        store.sets[set] = keys
+    }
+    keys[key] = value
+    return nil
+}
+
+
+func ( store *MemoryStorage ) Count( set string ) int {
+    return len( store.sets[set] )
+}
+
+
+func ( store *MemoryStorage ) AddPage( page Page ) error {
+    store.pages = append( store.pages, page )
+    return nil
+}
+
+
+func ( store *MemoryStorage ) Pages( fn func(Page) error ) error {
+
+    for _, page := range store.pages {
+        if err := fn( page ); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+
+func ( store *MemoryStorage ) Close() error {
+    return nil
+}
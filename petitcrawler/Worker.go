@@ -2,7 +2,11 @@ package petitcrawler
 
 
 import (
+    "bytes"
+    "context"
     "fmt"
+    "io"
+    "mime"
     "net/http"
     "net/url"
     "golang.org/x/net/html"
@@ -16,10 +20,35 @@ import (
 
 
 
-// One Worker process. Accepts urls in channel url. Accepts termination signal in shutdown.
+// ErrNotHTML is returned by Work for a URL that was fetched but isn't an HTML page, ex: a PDF
+var ErrNotHTML = errors.New("Not an HTML page.")
+
+// ErrRobots is returned by Work for a URL its host's robots.txt doesn't let us fetch
+var ErrRobots = errors.New("Disallowed by robots.txt.")
+
+
+// Fetcher holds what workers need to request pages.
+// It is shared by all workers, so it must not be changed once the crawl starts.
+type Fetcher struct {
+
+    Client *http.Client     // client to make requests with, nil means http.DefaultClient
+    UserAgent string        // User-Agent header to send, empty means the client's default
+    Limiters *HostLimiter   // spaces out requests to each host, nil means no limit
+    Retry *RetryPolicy      // which failures to retry, nil means DEFAULT_RETRY_POLICY
+    Headers []string        // response headers to record in Page.Headers, nil means DEFAULT_PAGE_HEADERS
+    Robots *RobotsCache     // robots.txt rules to obey, nil means robots.txt isn't checked
+
+}
+
+
+// One Worker process. Accepts urls in channel url. Accepts termination signal in shutdown,
+// or the end of ctx.
 // Process url received, send back to controller in send_back.
 // Send back successfully crawled page data to controller. 
-func Worker( myID int, urls chan string, send_back chan string, domain *url.URL, pages chan Page, shutdown <- chan bool, wg *sync.WaitGroup ) {
+// Once a url is fully processed a record of its attempts is sent back on done, so the controller knows
+// the worker is idle.
+// Pages are fetched with fetcher.
+func Worker( ctx context.Context, myID int, fetcher *Fetcher, urls chan Link, send_back chan Link, scope *Scope, pages chan Page, done chan Fetch, shutdown <- chan bool, wg *sync.WaitGroup ) {
 
     defer wg.Done()
     defer glog.Flush()
@@ -28,14 +57,41 @@ func Worker( myID int, urls chan string, send_back chan string, domain *url.URL,
         select {
             case _ = <- shutdown:
                 return
+            case <- ctx.Done():
+                return
             case link := <- urls:
-                p, err := Work( link, send_back, domain )
-                if err == nil {
+                p, err := Work( ctx, fetcher, link, send_back, scope )
+                f := Fetch{ URL: link.URL, Attempts: p.Attempts }
+                if link.Parent != "" {
+                    f.Referrers = []string{ link.Parent }
+                }
+                if len(p.Attempts) > 0 {
+                    f.Status = p.Attempts[len(p.Attempts)-1].Status
+                }
+                if err == ErrNotHTML {
+                    f.NotHTML = true
+                    f.ContentType = p.ContentType
+                } else if err == ErrRobots {
+                    f.Disallowed = true
+                } else if err != nil {
+                    f.Err = err.Error()
+                    f.Transient = len(p.Attempts) > 0 && p.Attempts[len(p.Attempts)-1].Retryable
+                } else {
+                    // The controller always takes pages until the crawl is over. If it's over, the URL
+                    // isn't done, so it stays in flight and is crawled again on resume.
                     select{
-                        case <-time.After(5*time.Second):
+                        case <-ctx.Done():
+                            return
                         case pages <- p:
                     }
                 } 
+                select{
+                    case _ = <- shutdown:
+                        return
+                    case <-ctx.Done():
+                        return
+                    case done <- f:
+                }
         }
     }
 
@@ -43,54 +99,108 @@ func Worker( myID int, urls chan string, send_back chan string, domain *url.URL,
 
 
 
-// Worker makes an http Get request to the given URL and parses the body of the html doc
-// using a separate recursive function
+// Worker makes an http Get request to the given URL with fetcher and parses the body of the html doc
+// using a separate recursive function. The request is aborted if ctx is done.
+// A nil fetcher uses http.DefaultClient.
+// Links found on the page are sent on uList one level deeper than link.
 // @Return is a create Page (urls, assets) and an integer 0 for success, -1 for fail
-func Work( link string, uList chan string, domain *url.URL ) (Page, error) {
+func Work( ctx context.Context, fetcher *Fetcher, l Link, uList chan Link, scope *Scope ) (Page, error) {
 
-    t0 := time.Now()
-    var page Page
+    link := l.URL
+    page := Page{ MyUrl: link, Depth: l.Depth, Parent: l.Parent }
 
     if govalidator.IsURL(link) == false {
         return page, errors.New( fmt.Sprintf("Not a url %s.",link))
     }
-    if err:= DomainCheck(domain); err!= nil{
-        return page, err
+    if scope == nil {
+        return page, errors.New("No scope to crawl in.")
+    }
+
+    // Check the host's robots.txt, fetching it if this is the host's first URL
+    if fetcher != nil && fetcher.Robots != nil {
+        u, err := url.Parse( link )
+        if err != nil {
+            return page, err
+        }
+        robots, err := fetcher.Robots.Get( ctx, u )
+        if err != nil {
+            return page, err
+        }
+        if robots.Allowed( fetcher.UserAgent, u ) == false {
+            glog.Info( fmt.Sprintf("robots.txt disallows %s, skipping", link) )
+            return page, ErrRobots
+        }
     }
     
     // Make a request 
     glog.Info( fmt.Sprintf("Requesting to URL %s.", link ) )
-    //timeout := time.Duration( 6 * time.Second)
-    //client := http.Client{ Timeout: timeout, } 
-    resp, err := http.Get(link)
-    
+    resp, err := fetch( ctx, fetcher, link, &page )
     if err != nil {
-
-        // Try one more time, but be respectful of websites! Do not send too many requests.
-        resp, err = http.Get(link)
-        if err != nil {
-            glog.Warning( fmt.Sprintf("No response from %s. Error is %s. Skipping URL.\n", link, err))
-            return page, errors.New( fmt.Sprintf("No response form %s. Error is %s.", link, err))
-        }
+        return page, err
     }
     defer resp.Body.Close()
-    
-    // If we have an error, log it and return
-    if resp.StatusCode != 200 {
-        glog.Warning( fmt.Sprintf("Bad response from http request to page %s. Error code %d. Skipping URL\n", link, resp.StatusCode))
-        return page, errors.New( fmt.Sprintf("Bad response code from request to page %s. Error code %d.", link, resp.StatusCode))
+
+    // Don't download what isn't a page
+    if isHTML( resp.Header.Get("Content-Type") ) == false {
+        page.Status = resp.StatusCode
+        page.FinalURL = resp.Request.URL.String()
+        page.ContentType = resp.Header.Get("Content-Type")
+        glog.Info( fmt.Sprintf("Not crawling %s, its content type is %s.", link, page.ContentType ) )
+        return page, ErrNotHTML
     }
 
-    // Parse the body of the response
-    doc, err := html.Parse( resp.Body )
+    // Read and parse the body of the response, hashing it and its text to find duplicate pages
+    body, err := io.ReadAll( resp.Body )
+    if err != nil {
+        glog.Warning( fmt.Sprintf("Unable to read page %s. Error is %s. Skipping URL.\n", link, err))
+        return page, errors.New( fmt.Sprintf("Unable to read page %s.", link))
+    }
+    // The time to parse the page starts once it is downloaded, not counting waits on the limiter or retries
+    t0 := time.Now()
+    last := page.Attempts[len(page.Attempts)-1]
+    page.Status = resp.StatusCode
+    page.FinalURL = resp.Request.URL.String()
+    for r := resp.Request; r.Response != nil; r = r.Response.Request {
+        page.Redirects = append( []Redirect{ { URL: r.Response.Request.URL.String(), Status: r.Response.StatusCode } }, page.Redirects... )
+    }
+    page.ContentType = resp.Header.Get("Content-Type")
+    page.ContentLength = int64( len(body) )
+    page.FetchedAt = last.Time
+    page.ResponseTime = time.Since( last.Time )
+    headers := DEFAULT_PAGE_HEADERS
+    if fetcher != nil && fetcher.Headers != nil {
+        headers = fetcher.Headers
+    }
+    for _, name := range headers {
+        if value := resp.Header.Get(name); value != "" {
+            if page.Headers == nil {
+                page.Headers = make( map[string]string )
+            }
+            page.Headers[http.CanonicalHeaderKey(name)] = value
+        }
+    }
+    page.BodyHash = hashHex( body )
+    doc, err := html.Parse( bytes.NewReader(body) )
+    if err == nil {
+        text := VisibleText(doc)
+        page.TextHash = hashHex( []byte(text) )
+        page.SimHash = SimHash( text )
+    }
     if err != nil {
         glog.Warning( fmt.Sprintf("Unable to parse html from page %s. Error is %s. Skipping URL.\n", link, err))
         return page, errors.New( fmt.Sprintf("Unable to parse html of page %s.", link))
     }
 
+    // Relative links are resolved against the page's final URL (after redirects), or its <base href>
+    base := resp.Request.URL
+    if href := FindBase( doc ); href != "" {
+        if b, err := base.Parse( href ); err == nil {
+            base = b
+        }
+    }
+
     // Search the html structure for links, static assets
-    err = CheckNode( doc, uList, domain, &page, t0 )
-    page.MyUrl = link
+    err = CheckNode( ctx, doc, uList, scope, base, &page, t0 )
 
     glog.Info( fmt.Sprintf("Done crawling link %s\n", link))
     if err != nil{ 
@@ -101,10 +211,100 @@ func Work( link string, uList chan string, domain *url.URL ) (Page, error) {
 }
 
 
+// isHTML reports if contentType is an HTML page. Servers that don't say are taken at their word that it is.
+func isHTML( contentType string ) bool {
+
+    if contentType == "" {
+        return true
+    }
+    mediaType, _, err := mime.ParseMediaType( contentType )
+    if err != nil {
+        return false
+    }
+    return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+
+// fetch requests link, retrying failures the fetcher's retry policy allows.
+// Every attempt is recorded in page.Attempts. Returns the response for a 200, otherwise an error.
+func fetch( ctx context.Context, fetcher *Fetcher, link string, page *Page ) (*http.Response, error) {
+
+    client := http.DefaultClient
+    policy := &DEFAULT_RETRY_POLICY
+    var limiters *HostLimiter
+    if fetcher != nil {
+        if fetcher.Client != nil {
+            client = fetcher.Client
+        }
+        if fetcher.Retry != nil {
+            policy = fetcher.Retry
+        }
+        limiters = fetcher.Limiters
+    }
+
+    for attempt := 1; ; attempt++ {
+
+        req, err := http.NewRequestWithContext( ctx, "GET", link, nil )
+        if err != nil {
+            return nil, errors.New( fmt.Sprintf("Unable to create request for %s. Error is %s.", link, err))
+        }
+        if fetcher != nil && fetcher.UserAgent != "" {
+            req.Header.Set( "User-Agent", fetcher.UserAgent )
+        }
+        limiter := limiters.For( req.URL.Host )
+        if err = limiter.Wait(ctx); err != nil {
+            return nil, err
+        }
+
+        a := Attempt{ Time: time.Now() }
+        resp, reqErr := client.Do(req)
+        a.Duration = time.Since(a.Time)
+        if reqErr != nil {
+            a.Err = reqErr.Error()
+            err = errors.New( fmt.Sprintf("No response form %s. Error is %s.", link, reqErr))
+        } else {
+            a.Status = resp.StatusCode
+            if resp.StatusCode == 200 {
+                page.Attempts = append( page.Attempts, a )
+                return resp, nil
+            }
+
+            // The server is overloaded, slow down all workers requesting from it
+            if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+                wait := retryAfter(resp)
+                glog.Warning( fmt.Sprintf("Server asked us to slow down on %s (code %d), pausing requests for %s.", link, resp.StatusCode, wait))
+                limiter.Backoff( wait )
+            }
+            io.Copy( io.Discard, io.LimitReader(resp.Body, 64*1024) )
+            resp.Body.Close()
+            a.Err = fmt.Sprintf("Bad response code %d", resp.StatusCode)
+            err = errors.New( fmt.Sprintf("Bad response code from request to page %s. Error code %d.", link, resp.StatusCode))
+        }
+        a.Retryable = ctx.Err() == nil && policy.Retryable( a.Status, reqErr )
+        page.Attempts = append( page.Attempts, a )
+
+        if a.Retryable == false || attempt >= policy.MaxAttempts {
+            glog.Warning( fmt.Sprintf("Giving up on %s after %d attempts. Error is %s. Skipping URL.\n", link, attempt, a.Err))
+            return nil, err
+        }
+
+        // Try again, but be respectful of websites! Wait longer each time.
+        if err = sleep( ctx, policy.Backoff(attempt) ); err != nil {
+            return nil, err
+        }
+    }
+}
+
+
 // CheckNode searches one node in a parsed HTML tree, looking for 
 // URLS and static assets to record. 
 // Information found is passed back through the @param page *Page.
-func CheckNode( n *html.Node, uList chan string, domain *url.URL, page *Page, t0 time.Time) error {
+// Relative links are resolved against base, the URL of the page (or its <base href>),
+// and only links that are in scope are sent back. In-domain links rejected by an include/exclude
+// rule are recorded in page.Rejected, with the rule.
+// Links are sent one level deeper than the page, with the page as their parent.
+// Stops early with ctx.Err() if ctx is done.
+func CheckNode( ctx context.Context, n *html.Node, uList chan Link, scope *Scope, base *url.URL, page *Page, t0 time.Time) error {
     
     if n == nil {
         return nil 
@@ -112,10 +312,18 @@ func CheckNode( n *html.Node, uList chan string, domain *url.URL, page *Page, t0
     if page == nil{
         return errors.New("Bad page struct pointer.")
     }
-    if err:= DomainCheck(domain); err!= nil{
+    if scope == nil {
+        return errors.New("No scope to crawl in.")
+    }
+    if err:= DomainCheck(base); err!= nil{
         return err
     }
 
+    // Record the page's title, description, canonical URL and language
+    if n.Type == html.ElementNode {
+        checkMeta( n, base, page )
+    }
+
     // Search for links, images, scripts
     if n.Type == html.ElementNode && ( n.Data == "a" || n.Data == "img" || n.Data == "link" || n.Data == "script") { 
 
@@ -126,50 +334,49 @@ func CheckNode( n *html.Node, uList chan string, domain *url.URL, page *Page, t0
 
             // Record sources of images, links, and scripts
             if a.Key == "src" {
-                page.Assets = append( page.Assets, a.Val )
+                addAsset( page, base, a.Val )
                 break
             }
 
             // Record new URLs found, and send them back to the parent crawler
             if a.Key == "href"  { 
                 // If we can't parse the URL and find the domain, skip this URL, since we can't understand it
-                str := a.Val
-                if len(a.Val) >0 && a.Val[0] == '.' {
-                    str = str[1:]
-                }
-                u,err := url.Parse( str )
+                u,err := base.Parse( strings.TrimSpace(a.Val) )
                 if err != nil {
                     continue
                 }
 
-                if u.Host == "" {
-                    u.Host = domain.Host
+                if n.Data == "a" && ( u.Scheme == "http" || u.Scheme == "https" ) {
+                    page.Anchors = append( page.Anchors, Anchor{ URL: u.String(), Text: VisibleText(n), Internal: scope.InScope(u) } )
                 }
                 if u.Scheme == "javascript" {
-                    page.Assets = append( page.Assets, a.Val )
+                    addAsset( page, base, a.Val )
                     break
                 }
                 if strings.Contains(u.String(), ".png") || strings.Contains(u.String(), ".jpg")|| strings.Contains(u.String(), ".ico") || strings.Contains(u.String(), ".css") {
-                    page.Assets = append( page.Assets, a.Val )
+                    addAsset( page, base, a.Val )
                     break
                 }
 
-                // Check to see if the discovered URL is within the original domain
-                if (u.Host == domain.Host || u.Host == domain.Path) && strings.Contains(u.String(), "mailto") == false {
-                    url := a.Val
-
-                    // Check to see if the URL is absolute, if not, specify a scheme
-                    if u.IsAbs() == false {
-                        u.Scheme = domain.Scheme
-                        url = u.String()
+                // Check to see if the discovered URL is within the original domain, and passes the rules
+                ok, reason := scope.Allowed(u)
+                if ok == false && scope.InScope(u) {
+                    if page.Rejected == nil {
+                        page.Rejected = make( map[string]string )
                     }
+                    page.Rejected[u.String()] = reason
+                }
+                if ok {
+                    url := u.String()
 
                     // Record this info in the Page, send to crawler with URL channel
                     page.BabyUrls = append( page.BabyUrls, url )
                     select{ 
                         case <-time.After(2*time.Second):
                             return errors.New("Timeout waiting for write to channel") 
-                        case uList <- url:
+                        case <-ctx.Done():
+                            return ctx.Err()
+                        case uList <- Link{ URL: url, Depth: page.Depth + 1, Parent: page.MyUrl }:
                     }
                 }
                 break
@@ -179,8 +386,73 @@ func CheckNode( n *html.Node, uList chan string, domain *url.URL, page *Page, t0
 
     // Recursively iterate over all nodes in the html parse tree
     for c := n.FirstChild; c != nil; c = c.NextSibling { 
-        err := CheckNode(c, uList, domain, page, t0)
+        err := CheckNode(ctx, c, uList, scope, base, page, t0)
         if err != nil { return err}
     }
     return nil
 }
+
+
+// addAsset records the asset link in page, as found and resolved against base
+func addAsset( page *Page, base *url.URL, link string ) {
+
+    page.Assets = append( page.Assets, link )
+    resolved := link
+    if u, err := base.Parse( strings.TrimSpace(link) ); err == nil {
+        resolved = u.String()
+    }
+    page.AssetURLs = append( page.AssetURLs, resolved )
+}
+
+
+// checkMeta records the page metadata held by element n, if any, in page. The first of each wins.
+func checkMeta( n *html.Node, base *url.URL, page *Page ) {
+
+    attrs := make( map[string]string )
+    for _, a := range n.Attr {
+        attrs[strings.ToLower(a.Key)] = strings.TrimSpace(a.Val)
+    }
+    switch n.Data {
+        case "html":
+            if page.Lang == "" {
+                page.Lang = attrs["lang"]
+            }
+        case "title":
+            if page.Title == "" && n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+                page.Title = strings.Join( strings.Fields( n.FirstChild.Data ), " " )
+            }
+        case "meta":
+            if page.Description == "" && strings.EqualFold( attrs["name"], "description" ) {
+                page.Description = attrs["content"]
+            }
+        case "link":
+            if page.Canonical == "" && attrs["href"] != "" && strings.EqualFold( attrs["rel"], "canonical" ) {
+                if u, err := base.Parse( attrs["href"] ); err == nil {
+                    page.Canonical = u.String()
+                }
+            }
+    }
+}
+
+
+// FindBase returns the href of the first <base> element in the document, or "" if it has none
+func FindBase( n *html.Node ) string {
+
+    if n == nil {
+        return ""
+    }
+    if n.Type == html.ElementNode && n.Data == "base" {
+        for _, a := range n.Attr {
+            if a.Key == "href" {
+                return strings.TrimSpace(a.Val)
+            }
+        }
+    }
+    for c := n.FirstChild; c != nil; c = c.NextSibling {
+        if href := FindBase(c); href != "" {
+            return href
+        }
+    }
+    return ""
+}
+
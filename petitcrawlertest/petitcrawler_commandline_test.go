@@ -4,35 +4,58 @@ package petitcrawler_test
 import (
     "petitcrawler"
     "testing"
+    "compress/gzip"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "encoding/xml"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "net/http/httptest"
     "time"
 )
 
 
-// Unit test New creates crawler with command line flags
+// Starting URL for the tests that crawl a real site
+var UrlPtr = flag.String("url", "", "Starting URL to crawl. This is mandatory.")
+
+
+// newCrawler creates a crawler for the -url command line arg
+func newCrawler( opts ...petitcrawler.Option ) (*petitcrawler.SingleCrawler, error) {
+    return petitcrawler.NewSingleCrawler( *UrlPtr, opts... )
+}
+
+
+// Unit test NewSingleCrawler creates crawler with default values
 // must pass in -url command line arg
 func TestNew(t *testing.T) {
     t.Parallel()
-    Mycrawler, err := petitcrawler.New() 
+    Mycrawler, err := newCrawler() 
     if err != nil {
         t.Fatalf("TestNew() failed: %s", err)
     }
-    if Mycrawler.NumWorkers != *petitcrawler.NumwPtr {
-        t.Fatalf("Incorrectly set num workers, should be %d, got: %d.", *petitcrawler.NumwPtr, Mycrawler.NumWorkers)
+    if Mycrawler.NumWorkers != petitcrawler.DEFAULT_NUM_WORKERS {
+        t.Fatalf("Incorrectly set num workers, should be %d, got: %d.", petitcrawler.DEFAULT_NUM_WORKERS, Mycrawler.NumWorkers)
     }
-    if Mycrawler.MAX_PAGES != *petitcrawler.MaxcPtr{
-        t.Fatalf("Incorrectly set max pages, should be %d, got: %d.", *petitcrawler.MaxcPtr, Mycrawler.MAX_PAGES)
+    if Mycrawler.MAX_PAGES != petitcrawler.DEFAULT_MAX_PAGES {
+        t.Fatalf("Incorrectly set max pages, should be %d, got: %d.", petitcrawler.DEFAULT_MAX_PAGES, Mycrawler.MAX_PAGES)
     }
-    if Mycrawler.PRINT_LIMIT != *petitcrawler.MaxpPtr{
-        t.Fatalf("Incorrectly set print limit, should be %d, got: %d.", *petitcrawler.MaxpPtr, Mycrawler.PRINT_LIMIT)
+    if Mycrawler.PRINT_LIMIT != petitcrawler.DEFAULT_PRINT_LIMIT {
+        t.Fatalf("Incorrectly set print limit, should be %d, got: %d.", petitcrawler.DEFAULT_PRINT_LIMIT, Mycrawler.PRINT_LIMIT)
     }
     if Mycrawler.NumPages != 0 {
         t.Fatalf("Incorrectly set num pages, should be %d, got: %d.", 0, Mycrawler.NumPages)
     }
-    if Mycrawler.MAX_TIME != (time.Duration(*petitcrawler.MaxtPtr))*(time.Second){
-        t.Fatalf("Incorrectly set max time, should be %d, got: %d.", *petitcrawler.MaxtPtr, Mycrawler.MAX_TIME)
+    if Mycrawler.MAX_TIME != petitcrawler.DEFAULT_MAX_TIME {
+        t.Fatalf("Incorrectly set max time, should be %s, got: %s.", petitcrawler.DEFAULT_MAX_TIME, Mycrawler.MAX_TIME)
     }
-    if len(Mycrawler.Sitemap) != *petitcrawler.MaxcPtr {
-        t.Fatalf("Incorrectly initialized Sitemap of crawler, should be %d, got: %d.", *petitcrawler.MaxcPtr, len(Mycrawler.Sitemap))
+    if _, ok := Mycrawler.Storage.(*petitcrawler.MemoryStorage); ok == false {
+        t.Fatalf("Incorrectly initialized Storage of crawler, should be in memory, got: %T.", Mycrawler.Storage)
     }
     if len(Mycrawler.Filename) >= 255 {
         t.Fatalf("Incorrectly initialized Filename of crawler, should be less that 255 in length, got: %d in length.", len(Mycrawler.Filename))
@@ -43,14 +66,925 @@ func TestNew(t *testing.T) {
 // Unit test Run function
 func TestRun(t *testing.T) {
 
-    Mycrawler, err := petitcrawler.New()
+    Mycrawler, err := newCrawler()
     if err != nil {
         t.Fatalf("TestRun() failed: %s", err)
     }
 
-    err = petitcrawler.Run(Mycrawler)
+    err = Mycrawler.Run()
     if err !=nil {
         t.Fatalf("TestRun() failed. %s", err)
     }
 }
 
+
+// Unit test NewSingleCrawler applies options, and rejects bad ones
+func TestNewSingleCrawlerOptions(t *testing.T) {
+    c, err := petitcrawler.NewSingleCrawler( "http://example.com",
+        petitcrawler.WithMaxPages(20),
+        petitcrawler.WithNumWorkers(3),
+        petitcrawler.WithMaxTime(5*time.Second),
+        petitcrawler.WithFilename("out.txt") )
+    if err != nil {
+        t.Fatalf("TestNewSingleCrawlerOptions() failed: %s", err)
+    }
+    if c.MAX_PAGES != 20 || c.NumWorkers != 3 || c.MAX_TIME != 5*time.Second || c.Filename != "out.txt" {
+        t.Fatalf("TestNewSingleCrawlerOptions() failed: options not applied, got %+v.", c)
+    }
+
+    c, err = petitcrawler.NewSingleCrawler( "http://example.com" )
+    if err != nil {
+        t.Fatalf("TestNewSingleCrawlerOptions() failed: %s", err)
+    }
+    if c.Filename != "example.com.txt" {
+        t.Fatalf("TestNewSingleCrawlerOptions() failed: default Filename should be example.com.txt, got %s.", c.Filename)
+    }
+
+    if _, err = petitcrawler.NewSingleCrawler( "notaurl" ); err == nil {
+        t.Fatalf("TestNewSingleCrawlerOptions() failed: Expecting to fail on bad url.")
+    }
+    if _, err = petitcrawler.NewSingleCrawler( "http://example.com", petitcrawler.WithNumWorkers(0) ); err == nil {
+        t.Fatalf("TestNewSingleCrawlerOptions() failed: Expecting to fail on 0 workers.")
+    }
+    if _, err = petitcrawler.NewSingleCrawler( "http://example.com", petitcrawler.WithNumWorkers(petitcrawler.MAX_WORKERS+1) ); err == nil {
+        t.Fatalf("TestNewSingleCrawlerOptions() failed: Expecting to fail on too many workers.")
+    }
+    if _, err = petitcrawler.NewSingleCrawler( "http://example.com", petitcrawler.WithMaxPages(-1) ); err == nil {
+        t.Fatalf("TestNewSingleCrawlerOptions() failed: Expecting to fail on negative max pages.")
+    }
+}
+
+
+// Unit test RunContext stops a crawl of a hanging server when the deadline passes
+func TestRunContextDeadline(t *testing.T) {
+    release := make( chan bool )
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        select {
+            case <- release:
+            case <- r.Context().Done():
+        }
+    }))
+    defer ts.Close()
+    defer close(release)
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunContextDeadline() Failed to create crawler. %s.", err)
+    }
+
+    ctx, cancel := context.WithTimeout( context.Background(), 200*time.Millisecond )
+    defer cancel()
+    t0 := time.Now()
+    err = c.RunContext( ctx )
+    if err != context.DeadlineExceeded {
+        t.Fatalf("TestRunContextDeadline() failed: Expecting %s, got %v.", context.DeadlineExceeded, err)
+    }
+    if c.Reason != petitcrawler.StopCancelled {
+        t.Fatalf("TestRunContextDeadline() failed: Expecting to stop with %s, got %s.", petitcrawler.StopCancelled, c.Reason)
+    }
+    if time.Since(t0) > 5*time.Second {
+        t.Fatalf("TestRunContextDeadline() failed: took %s to stop.", time.Since(t0))
+    }
+}
+
+
+// Unit test Run stops as soon as a small site has been fully crawled
+func TestRunFrontierExhausted(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        fmt.Fprintf( w, `<html><body><img src="%s.png"><a href="/a">a</a><a href="/b">b</a></body></html>`, r.URL.Path )
+    }))
+    defer ts.Close()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(4),
+        petitcrawler.WithMaxTime(time.Minute),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunFrontierExhausted() Failed to create crawler. %s.", err)
+    }
+
+    t0 := time.Now()
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunFrontierExhausted() failed: %s", err)
+    }
+    if c.Reason != petitcrawler.StopFrontierExhausted {
+        t.Fatalf("TestRunFrontierExhausted() failed: Expecting to stop with %s, got %s.", petitcrawler.StopFrontierExhausted, c.Reason)
+    }
+    if c.NumPages != 3 {
+        t.Fatalf("TestRunFrontierExhausted() failed: Expecting 3 pages, got %d.", c.NumPages)
+    }
+    if time.Since(t0) > 10*time.Second {
+        t.Fatalf("TestRunFrontierExhausted() failed: took %s to stop.", time.Since(t0))
+    }
+}
+
+
+// Unit test Run records URLs that failed, and if they failed transiently or permanently
+func TestRunFailed(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/down":
+                w.WriteHeader( http.StatusInternalServerError )
+            case "/gone":
+                w.WriteHeader( http.StatusNotFound )
+            case "/report.pdf":
+                w.Header().Set( "Content-Type", "application/pdf" )
+                fmt.Fprint( w, "%PDF-1.4" )
+            case "/other":
+                fmt.Fprint( w, `<html><body><a href="/gone">g</a></body></html>` )
+            default:
+                fmt.Fprint( w, `<html><body><a href="/down">d</a><a href="/gone">g</a><a href="/other">o</a><a href="/report.pdf">r</a></body></html>` )
+        }
+    }))
+    defer ts.Close()
+
+    policy := petitcrawler.DEFAULT_RETRY_POLICY
+    policy.BaseDelay = time.Millisecond
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithRetryPolicy(policy),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunFailed() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunFailed() failed: %s", err)
+    }
+    if len(c.Failed) != 2 {
+        t.Fatalf("TestRunFailed() failed: Expecting 2 failed URLs, got %d.", len(c.Failed))
+    }
+    for _, f := range c.Failed {
+        if f.URL == ts.URL + "/down" && (f.Transient == false || len(f.Attempts) != 3) {
+            t.Fatalf("TestRunFailed() failed: Expecting /down to fail transiently after 3 attempts, got %+v.", f)
+        }
+        if f.URL == ts.URL + "/gone" && (f.Transient == true || len(f.Attempts) != 1 || f.Status != http.StatusNotFound) {
+            t.Fatalf("TestRunFailed() failed: Expecting /gone to fail permanently after 1 attempt, got %+v.", f)
+        }
+        if f.URL == ts.URL + "/gone" && (len(f.Referrers) != 2 || f.Referrers[0] != ts.URL + "/") {
+            t.Fatalf("TestRunFailed() failed: Expecting /gone linked from / and /other, got %v.", f.Referrers)
+        }
+    }
+    if len(c.NonHTML) != 1 || c.NonHTML[0].URL != ts.URL + "/report.pdf" || c.NonHTML[0].ContentType != "application/pdf" || c.NumPages != 2 {
+        t.Fatalf("TestRunFailed() failed: Expecting /report.pdf recorded as non-HTML and 2 pages, got %+v and %d pages.", c.NonHTML, c.NumPages)
+    }
+}
+
+
+// Unit test Run doesn't count the time spent waiting on the rate limiter against parsing a page.
+// The site has more links than the limiter's burst, so with the default workers and rate
+// the last pages wait several seconds before being fetched.
+func TestRunRateLimited(t *testing.T) {
+    n := 80
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        fmt.Fprint( w, `<html><body><a href="/">home</a>` )
+        if r.URL.Path == "/" {
+            for i := 0; i < n; i++ {
+                fmt.Fprintf( w, `<a href="/p%d">%d</a>`, i, i )
+            }
+        }
+        fmt.Fprint( w, `</body></html>` )
+    }))
+    defer ts.Close()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunRateLimited() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunRateLimited() failed: %s", err)
+    }
+    if c.NumPages != n + 1 || len(c.Failed) != 0 {
+        t.Fatalf("TestRunRateLimited() failed: Expecting %d pages and none failed, got %d pages and %d failed.", n + 1, c.NumPages, len(c.Failed))
+    }
+}
+
+
+// Unit test Run only follows links allowed by include/exclude rules, and records the rule that rejected the rest
+func TestRunRules(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        fmt.Fprintf( w, `<html><body><img src="%s.png"><a href="/docs/a">a</a><a href="/docs/search">s</a><a href="/blog">b</a></body></html>`, r.URL.Path )
+    }))
+    defer ts.Close()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL + "/docs/", petitcrawler.WithNumWorkers(2),
+        petitcrawler.WithInclude("/docs/"), petitcrawler.WithExclude("/docs/search"),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunRules() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunRules() failed: %s", err)
+    }
+    if c.NumPages != 2 {
+        t.Fatalf("TestRunRules() failed: Expecting 2 pages, got %d.", c.NumPages)
+    }
+    if c.Excluded[ts.URL + "/docs/search"] != "exclude prefix:/docs/search" || c.Excluded[ts.URL + "/blog"] != "no include rule matched" {
+        t.Fatalf("TestRunRules() failed: Expecting rejected URLs with their rules, got %v.", c.Excluded)
+    }
+}
+
+
+// Unit test Run fetches links as they were found, only using their normalized form to tell if they were seen
+func TestRunTrailingSlash(t *testing.T) {
+    var mu sync.Mutex
+    requested := make( map[string]int )
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        mu.Lock()
+        requested[r.URL.RequestURI()]++
+        mu.Unlock()
+        fmt.Fprint( w, `<html><body><a href="/docs/">d</a><a href="/docs/#top">t</a><a href="/a?y=2&x=1">a</a></body></html>` )
+    }))
+    defer ts.Close()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunTrailingSlash() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunTrailingSlash() failed: %s", err)
+    }
+    mu.Lock()
+    defer mu.Unlock()
+    if len(requested) != 4 || requested["/"] != 1 || requested["/docs/"] != 1 || requested["/a?y=2&x=1"] != 1 {
+        t.Fatalf("TestRunTrailingSlash() failed: Expecting robots.txt, /, /docs/ and /a?y=2&x=1 requested once each, got %v.", requested)
+    }
+}
+
+
+// Unit test Run records the depth and parent of each page, and stops following links past MaxDepth
+func TestRunMaxDepth(t *testing.T) {
+    // A chain of pages /0 -> /1 -> /2 -> /3 ...
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        n := 0
+        fmt.Sscanf( r.URL.Path, "/%d", &n )
+        fmt.Fprintf( w, `<html><body><img src="/%d.png"><a href="/%d">next</a></body></html>`, n, n+1 )
+    }))
+    defer ts.Close()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL + "/0", petitcrawler.WithNumWorkers(2), petitcrawler.WithMaxDepth(2),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunMaxDepth() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunMaxDepth() failed: %s", err)
+    }
+    if c.NumPages != 3 {
+        t.Fatalf("TestRunMaxDepth() failed: Expecting 3 pages, got %d.", c.NumPages)
+    }
+    c.Storage.Pages( func( p petitcrawler.Page ) error {
+        if p.MyUrl != fmt.Sprintf("%s/%d", ts.URL, p.Depth) {
+            t.Fatalf("TestRunMaxDepth() failed: %s should not be at depth %d.", p.MyUrl, p.Depth)
+        }
+        if p.Depth > 0 && p.Parent != fmt.Sprintf("%s/%d", ts.URL, p.Depth-1) {
+            t.Fatalf("TestRunMaxDepth() failed: %s found on %s.", p.MyUrl, p.Parent)
+        }
+        return nil
+    })
+    if c.Excluded[ts.URL + "/3"] != "max depth" {
+        t.Fatalf("TestRunMaxDepth() failed: Expecting /3 excluded for depth, got %v.", c.Excluded)
+    }
+}
+
+
+// Unit test Run keeps pages without assets by URL, and with DedupBody/DedupText keeps one of pages with the same content
+func TestRunDedup(t *testing.T) {
+    // /copy is /a with different markup, /mirror is /a byte for byte, /img and /app are different pages without text
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/a", "/mirror":
+                fmt.Fprint( w, `<html><body><p>Same text</p></body></html>` )
+            case "/copy":
+                fmt.Fprint( w, `<html><head><style>p {}</style></head><body><div>Same   text</div></body></html>` )
+            case "/img":
+                fmt.Fprint( w, `<html><body><img src="/photo.jpg"></body></html>` )
+            case "/app":
+                fmt.Fprint( w, `<html><body><div id="app"></div><script src="/app.js"></script></body></html>` )
+            default:
+                fmt.Fprint( w, `<html><body><a href="/a">a</a><a href="/mirror">m</a><a href="/copy">c</a><a href="/img">i</a><a href="/app">p</a></body></html>` )
+        }
+    }))
+    defer ts.Close()
+
+    tests := []struct {
+        mode petitcrawler.DedupMode
+        pages int
+        aliases []string
+    }{
+        { petitcrawler.DedupURL, 6, nil },
+        { petitcrawler.DedupBody, 5, []string{ ts.URL + "/mirror" } },
+        { petitcrawler.DedupText, 4, []string{ ts.URL + "/mirror", ts.URL + "/copy" } },
+    }
+    for _, test := range tests {
+        // One worker, so pages are collected in the order they are linked
+        c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(1), petitcrawler.WithDedup(test.mode),
+            petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+        if err != nil {
+            t.Fatalf("TestRunDedup() Failed to create crawler. %s.", err)
+        }
+        if err = c.Run(); err != nil {
+            t.Fatalf("TestRunDedup() failed: %s", err)
+        }
+        if c.NumPages != test.pages {
+            t.Fatalf("TestRunDedup() failed: Expecting %d pages with mode %d, got %d.", test.pages, test.mode, c.NumPages)
+        }
+        var aliases []string
+        c.EachPage( func( p petitcrawler.Page ) error {
+            if p.MyUrl == ts.URL + "/a" {
+                aliases = p.Aliases
+            }
+            return nil
+        })
+        if fmt.Sprint(aliases) != fmt.Sprint(test.aliases) {
+            t.Fatalf("TestRunDedup() failed: Expecting /a to have aliases %v with mode %d, got %v.", test.aliases, test.mode, aliases)
+        }
+    }
+
+    if _, err := petitcrawler.ParseDedupMode("md5"); err == nil {
+        t.Fatalf("TestRunDedup() failed: Expecting unknown dedup mode to fail.")
+    }
+}
+
+
+// Unit test Run reports pages with near identical text, ex: a printer-friendly copy, as near duplicates
+func TestRunNearDuplicates(t *testing.T) {
+    var words []string
+    for i := 0; i < 200; i++ {
+        words = append( words, fmt.Sprintf("word%d", i) )
+    }
+    article := strings.Join( words, " " )
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/article":
+                fmt.Fprintf( w, `<html><body><nav><a href="/print">Print this page</a></nav><p>%s</p></body></html>`, article )
+            case "/print":
+                fmt.Fprintf( w, `<html><body><p>%s</p></body></html>`, article )
+            default:
+                fmt.Fprint( w, `<html><body><a href="/article">article</a></body></html>` )
+        }
+    }))
+    defer ts.Close()
+
+    dir := t.TempDir()
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(1), petitcrawler.WithFilename( dir + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunNearDuplicates() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunNearDuplicates() failed: %s", err)
+    }
+    clusters, err := c.NearDuplicates()
+    if err != nil || fmt.Sprint(clusters) != fmt.Sprint( [][]string{ { ts.URL + "/article", ts.URL + "/print" } } ) {
+        t.Fatalf("TestRunNearDuplicates() failed: Expecting /article and /print clustered, got %v, %v.", clusters, err)
+    }
+    out, _ := os.ReadFile( dir + "/sitemap.txt" )
+    if strings.Contains( string(out), "Near duplicate pages (1 clusters" ) == false {
+        t.Fatalf("TestRunNearDuplicates() failed: Expecting the cluster printed, got:\n%s", out)
+    }
+}
+
+
+// Unit test WriteSitemap writes one sitemap, or splits it with an index, leaving out non-canonical pages
+// and listing redirected pages once, at the URL they redirect to
+func TestWriteSitemap(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/dup":
+                fmt.Fprint( w, `<html><head><link rel="canonical" href="/a"></head><body>dup</body></html>` )
+            case "/a":
+                w.Header().Set( "Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT" )
+                fmt.Fprint( w, `<html><body>a</body></html>` )
+            case "/old":
+                http.Redirect( w, r, "/c", http.StatusMovedPermanently )
+            case "/c":
+                fmt.Fprint( w, `<html><head><link rel="canonical" href="/c#main"></head><body>c</body></html>` )
+            default:
+                fmt.Fprint( w, `<html><body><a href="/a">a</a><a href="/b?x=1&amp;y=2">b</a><a href="/c">c</a><a href="/dup">d</a><a href="/old">o</a></body></html>` )
+        }
+    }))
+    defer ts.Close()
+
+    dir := t.TempDir()
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(1), petitcrawler.WithFilename( dir + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestWriteSitemap() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestWriteSitemap() failed: %s", err)
+    }
+
+    type urlset struct {
+        URLs []struct {
+            Loc string `xml:"loc"`
+            LastMod string `xml:"lastmod"`
+            ChangeFreq string `xml:"changefreq"`
+            Priority string `xml:"priority"`
+        } `xml:"url"`
+    }
+    files, err := c.WriteSitemap( dir + "/sitemap.xml", petitcrawler.SitemapOptions{ ChangeFreq: "weekly", HasPriority: true, Priority: 0.25 } )
+    if err != nil || len(files) != 1 {
+        t.Fatalf("TestWriteSitemap() failed: Expecting one file, got %v, %v.", files, err)
+    }
+    out, _ := os.ReadFile( files[0] )
+    var set urlset
+    if err = xml.Unmarshal( out, &set ); err != nil || len(set.URLs) != 4 {
+        t.Fatalf("TestWriteSitemap() failed: Expecting 4 URLs, got %v:\n%s", err, out)
+    }
+    for _, u := range set.URLs {
+        if u.Loc == ts.URL + "/dup" || u.Loc == ts.URL + "/old" || u.ChangeFreq != "weekly" || u.Priority != "0.25" {
+            t.Fatalf("TestWriteSitemap() failed: Unexpected entry %+v.", u)
+        }
+        if u.Loc == ts.URL + "/a" && u.LastMod != "2015-10-21T07:28:00Z" {
+            t.Fatalf("TestWriteSitemap() failed: Expecting lastmod from Last-Modified, got %+v.", u)
+        }
+    }
+    if strings.Contains( string(out), "x=1&amp;y=2" ) == false {
+        t.Fatalf("TestWriteSitemap() failed: Expecting & escaped in URLs, got:\n%s", out)
+    }
+    files, err = c.WriteSitemap( dir + "/zero.xml", petitcrawler.SitemapOptions{ HasPriority: true } )
+    if out, _ = os.ReadFile( dir + "/zero.xml" ); err != nil || strings.Count( string(out), "<priority>0</priority>" ) != 4 {
+        t.Fatalf("TestWriteSitemap() failed: Expecting priority 0 written, got %v:\n%s", err, out)
+    }
+
+    // Split 2 URLs per file, gzipped, with an index
+    files, err = c.WriteSitemap( dir + "/split.xml", petitcrawler.SitemapOptions{ Gzip: true, MaxURLs: 2, BaseURL: "https://cdn.example.com/maps" } )
+    if err != nil || len(files) != 3 || files[2] != dir + "/split.xml.gz" {
+        t.Fatalf("TestWriteSitemap() failed: Expecting 2 sitemaps and an index, got %v, %v.", files, err)
+    }
+    for i, file := range files {
+        f, _ := os.Open( file )
+        gz, err := gzip.NewReader( f )
+        if err != nil {
+            t.Fatalf("TestWriteSitemap() failed: %s isn't gzipped. %s.", file, err)
+        }
+        out, _ = io.ReadAll( gz )
+        f.Close()
+        if i < 2 {
+            set = urlset{}
+            if err = xml.Unmarshal( out, &set ); err != nil || len(set.URLs) != 2 {
+                t.Fatalf("TestWriteSitemap() failed: Expecting 2 URLs in %s, got %v:\n%s", file, err, out)
+            }
+        } else if strings.Contains( string(out), "<loc>https://cdn.example.com/maps/split-2.xml.gz</loc>" ) == false {
+            t.Fatalf("TestWriteSitemap() failed: Expecting the index to list split-2.xml.gz, got:\n%s", out)
+        }
+    }
+
+    if _, err = c.WriteSitemap( dir + "/bad.xml", petitcrawler.SitemapOptions{ ChangeFreq: "often" } ); err == nil {
+        t.Fatalf("TestWriteSitemap() failed: Expecting an unknown change frequency to fail.")
+    }
+}
+
+
+// countingOutput records what a crawler's Output is called with
+type countingOutput struct {
+    calls []string
+}
+
+func ( out *countingOutput ) Extension() string { return ".count" }
+func ( out *countingOutput ) Begin( c *petitcrawler.SingleCrawler ) error { out.calls = append( out.calls, "begin" ); return nil }
+func ( out *countingOutput ) Page( c *petitcrawler.SingleCrawler, p petitcrawler.Page ) error { out.calls = append( out.calls, "page" ); return nil }
+func ( out *countingOutput ) End( c *petitcrawler.SingleCrawler ) error { out.calls = append( out.calls, "end" ); return nil }
+
+
+// Unit test Run writes the sitemap in the JSON and JSON Lines formats, and streams pages to the Output
+func TestRunFormats(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/gone":
+                w.WriteHeader( http.StatusNotFound )
+            default:
+                fmt.Fprintf( w, `<html><head><title>Page %s</title></head><body><img src="%s.png"><a href="/a">a</a><a href="/b">b</a><a href="/gone">g</a></body></html>`, r.URL.Path, r.URL.Path )
+        }
+    }))
+    defer ts.Close()
+    dir := t.TempDir()
+
+    out := &countingOutput{}
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithOutput(out) )
+    if err != nil {
+        t.Fatalf("TestRunFormats() Failed to create crawler. %s.", err)
+    }
+    if c.Filename != strings.TrimPrefix( ts.URL, "http://" ) + ".count" {
+        t.Fatalf("TestRunFormats() failed: Expecting the default filename to use the output's extension, got %s.", c.Filename)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunFormats() failed: %s", err)
+    }
+    if strings.Join( out.calls, " " ) != "begin page page page end" {
+        t.Fatalf("TestRunFormats() failed: Expecting begin, a page for each of 3 pages and end, got %v.", out.calls)
+    }
+
+    c, err = petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithFormat("json"),
+        petitcrawler.WithFilename( dir + "/sitemap.json" ) )
+    if err != nil {
+        t.Fatalf("TestRunFormats() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunFormats() failed: %s", err)
+    }
+    var doc petitcrawler.JSONSitemap
+    data, _ := os.ReadFile( dir + "/sitemap.json" )
+    if err = json.Unmarshal( data, &doc ); err != nil {
+        t.Fatalf("TestRunFormats() failed: Unable to read JSON sitemap. %s.", err)
+    }
+    if doc.Schema != petitcrawler.JSON_SCHEMA || doc.Version != petitcrawler.JSON_SCHEMA_VERSION || len(doc.Pages) != 3 || len(doc.Failed) != 1 {
+        t.Fatalf("TestRunFormats() failed: Expecting versioned document with 3 pages and 1 failed URL, got:\n%s", data)
+    }
+    for _, p := range doc.Pages {
+        if p.Status != 200 || strings.HasPrefix( p.Title, "Page " ) == false || len(p.Assets) != 1 || len(p.SimHash) != 16 {
+            t.Fatalf("TestRunFormats() failed: Unexpected page %+v.", p)
+        }
+    }
+
+    c, err = petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithFormat("jsonl"),
+        petitcrawler.WithFilename( dir + "/sitemap.jsonl" ) )
+    if err != nil {
+        t.Fatalf("TestRunFormats() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunFormats() failed: %s", err)
+    }
+    data, _ = os.ReadFile( dir + "/sitemap.jsonl" )
+    var types []string
+    for _, line := range strings.Split( strings.TrimSpace( string(data) ), "\n" ) {
+        var record struct{ Type string `json:"type"`; Version int `json:"version"` }
+        if err = json.Unmarshal( []byte(line), &record ); err != nil {
+            t.Fatalf("TestRunFormats() failed: Bad JSON line %s. %s.", line, err)
+        }
+        types = append( types, record.Type )
+    }
+    if strings.Join( types, " " ) != "crawl page page page failed near_duplicates end" {
+        t.Fatalf("TestRunFormats() failed: Unexpected JSON lines:\n%s", data)
+    }
+
+    if _, err = petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithFormat("yaml") ); err == nil {
+        t.Fatalf("TestRunFormats() failed: Expecting unknown format to fail.")
+    }
+}
+
+
+// Unit test Run writes pages, links and assets tables in the CSV and TSV formats, with assets resolved against <base href>
+func TestRunCSV(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/a":
+                fmt.Fprint( w, `<html><head><title>A, "quoted"</title><base href="/static/"></head><body><img src="pic.jpg"></body></html>` )
+            default:
+                fmt.Fprint( w, `<html><head><title>Home</title><link href="/style.css"><script src="app.js"></script></head>
+                    <body><img src="/logo.png"><a href="/a">Go  to <b>A</b></a><a href="https://example.org/x">Elsewhere</a></body></html>` )
+        }
+    }))
+    defer ts.Close()
+    dir := t.TempDir()
+
+    readTable := func( name string, comma rune ) [][]string {
+        f, err := os.Open( name )
+        if err != nil {
+            t.Fatalf("TestRunCSV() failed: %s", err)
+        }
+        defer f.Close()
+        r := csv.NewReader( f )
+        r.Comma = comma
+        rows, err := r.ReadAll()
+        if err != nil {
+            t.Fatalf("TestRunCSV() failed: Unable to read %s. %s.", name, err)
+        }
+        return rows
+    }
+
+    for _, format := range []string{ "csv", "tsv" } {
+        c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(1), petitcrawler.WithFormat(format),
+            petitcrawler.WithFilename( dir + "/crawl." + format ) )
+        if err != nil {
+            t.Fatalf("TestRunCSV() Failed to create crawler. %s.", err)
+        }
+        if err = c.Run(); err != nil {
+            t.Fatalf("TestRunCSV() failed: %s", err)
+        }
+        comma := ','
+        if format == "tsv" {
+            comma = '\t'
+        }
+
+        pages := readTable( dir + "/crawl-pages." + format, comma )
+        if len(pages) != 3 || pages[0][0] != "url" || pages[1][2] != "200" || pages[1][4] != "Home" || pages[2][4] != `A, "quoted"` || pages[2][5] != "1" {
+            t.Fatalf("TestRunCSV() failed: Unexpected pages table %q.", pages)
+        }
+        links := readTable( dir + "/crawl-links." + format, comma )
+        expect := [][]string{ { "source", "target", "anchor_text", "type" },
+            { ts.URL + "/", ts.URL + "/a", "Go to A", "internal" },
+            { ts.URL + "/", "https://example.org/x", "Elsewhere", "external" } }
+        if fmt.Sprint(links) != fmt.Sprint(expect) {
+            t.Fatalf("TestRunCSV() failed: Expecting links %q, got %q.", expect, links)
+        }
+        assets := readTable( dir + "/crawl-assets." + format, comma )
+        expect = [][]string{ { "page", "asset", "type" },
+            { ts.URL + "/", ts.URL + "/style.css", "stylesheet" },
+            { ts.URL + "/", ts.URL + "/app.js", "script" },
+            { ts.URL + "/", ts.URL + "/logo.png", "image" },
+            { ts.URL + "/a", ts.URL + "/static/pic.jpg", "image" } }
+        if fmt.Sprint(assets) != fmt.Sprint(expect) {
+            t.Fatalf("TestRunCSV() failed: Expecting assets %q, got %q.", expect, assets)
+        }
+    }
+}
+
+
+// Unit test the link graph of a crawl, collapsed by path prefix, and its DOT and GraphML outputs
+func TestRunGraph(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/gone":
+                w.WriteHeader( http.StatusNotFound )
+            case "/docs/a":
+                fmt.Fprint( w, `<html><head><title>A</title></head><body><a href="/docs/b">b</a><a href="/">home</a><a href="/">again</a></body></html>` )
+            case "/docs/b":
+                fmt.Fprint( w, `<html><head><title>B</title></head><body><a href="/">home</a></body></html>` )
+            default:
+                fmt.Fprint( w, `<html><head><title>Home</title></head><body><a href="/docs/a">a</a><a href="/docs/b">b</a><a href="/gone">g</a></body></html>` )
+        }
+    }))
+    defer ts.Close()
+    dir := t.TempDir()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(1), petitcrawler.WithFormat("dot"),
+        petitcrawler.WithFilename( dir + "/graph.dot" ) )
+    if err != nil {
+        t.Fatalf("TestRunGraph() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunGraph() failed: %s", err)
+    }
+
+    graph, err := c.Graph(0)
+    if err != nil || len(graph.Nodes) != 4 || len(graph.Edges) != 6 {
+        t.Fatalf("TestRunGraph() failed: Expecting 4 nodes and 6 edges, got %+v, %v.", graph, err)
+    }
+    for _, n := range graph.Nodes {
+        if n.ID == ts.URL + "/gone" && (n.Status != 404 || n.Pages != 0 || n.Depth != 1) {
+            t.Fatalf("TestRunGraph() failed: Expecting /gone with status 404 at depth 1, got %+v.", n)
+        }
+        if n.ID == ts.URL + "/docs/a" && (n.Status != 200 || n.Depth != 1 || n.Title != "A") {
+            t.Fatalf("TestRunGraph() failed: Unexpected node %+v.", n)
+        }
+    }
+
+    graph, err = c.Graph(1)
+    expect := fmt.Sprint( []petitcrawler.GraphEdge{
+        { From: ts.URL + "/", To: ts.URL + "/docs", Count: 2 },
+        { From: ts.URL + "/", To: ts.URL + "/gone", Count: 1 },
+        { From: ts.URL + "/docs", To: ts.URL + "/", Count: 2 } } )
+    if err != nil || len(graph.Nodes) != 3 || fmt.Sprint(graph.Edges) != expect {
+        t.Fatalf("TestRunGraph() failed: Expecting collapsed edges %s, got %+v, %v.", expect, graph, err)
+    }
+    if graph.Nodes[1].ID != ts.URL + "/docs" || graph.Nodes[1].Pages != 2 || graph.Nodes[1].Depth != 1 {
+        t.Fatalf("TestRunGraph() failed: Expecting /docs to group 2 pages, got %+v.", graph.Nodes[1])
+    }
+
+    out, _ := os.ReadFile( dir + "/graph.dot" )
+    if strings.HasPrefix( string(out), "digraph sitemap {" ) == false || strings.Count( string(out), " -> " ) != 6 ||
+        strings.Contains( string(out), `"` + ts.URL + `/gone" [label="` + ts.URL + `/gone", status=404` ) == false {
+        t.Fatalf("TestRunGraph() failed: Unexpected DOT output:\n%s", out)
+    }
+
+    // Only nodes with an error status or a failed fetch are red, not links that weren't crawled
+    graph = &petitcrawler.Graph{ Nodes: []petitcrawler.GraphNode{ { ID: ts.URL + "/gone", Status: 404 },
+        { ID: ts.URL + "/down", Failed: true }, { ID: ts.URL + "/queued" } } }
+    var dot strings.Builder
+    if err = graph.WriteDOT( &dot ); err != nil {
+        t.Fatalf("TestRunGraph() failed: %s", err)
+    }
+    for _, line := range strings.Split( dot.String(), "\n" ) {
+        red := strings.Contains( line, "color=red" )
+        if strings.Contains( line, "[label=" ) && red == strings.Contains( line, "/queued" ) {
+            t.Fatalf("TestRunGraph() failed: Wrong color on DOT node %s", line)
+        }
+    }
+
+    c, err = petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(1), petitcrawler.WithFormat("graphml"),
+        petitcrawler.WithGraphCollapse(1), petitcrawler.WithFilename( dir + "/graph.graphml" ) )
+    if err != nil {
+        t.Fatalf("TestRunGraph() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunGraph() failed: %s", err)
+    }
+    var doc struct {
+        Nodes []struct{ ID string `xml:"id,attr"` } `xml:"graph>node"`
+        Edges []struct{ Data string `xml:"data"` } `xml:"graph>edge"`
+    }
+    out, _ = os.ReadFile( dir + "/graph.graphml" )
+    if err = xml.Unmarshal( out, &doc ); err != nil || len(doc.Nodes) != 3 || len(doc.Edges) != 3 || doc.Edges[0].Data != "2" {
+        t.Fatalf("TestRunGraph() failed: Unexpected GraphML output %v:\n%s", err, out)
+    }
+}
+
+
+// Unit test Run writes a self-contained HTML report with broken links, redirect chains and the site tree
+func TestRunReport(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/gone":
+                w.WriteHeader( http.StatusNotFound )
+            case "/old":
+                http.Redirect( w, r, "/docs/new", http.StatusMovedPermanently )
+            case "/docs/new":
+                fmt.Fprint( w, `<html><head><title>New &lt;script&gt;alert(1)&lt;/script&gt;</title></head><body><a href="/gone">g</a></body></html>` )
+            default:
+                fmt.Fprint( w, `<html><head><title>Home</title></head><body><a href="/old">o</a><a href="/gone">g</a></body></html>` )
+        }
+    }))
+    defer ts.Close()
+    dir := t.TempDir()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(1), petitcrawler.WithFormat("report"),
+        petitcrawler.WithFilename( dir + "/report.html" ) )
+    if err != nil {
+        t.Fatalf("TestRunReport() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunReport() failed: %s", err)
+    }
+    data, _ := os.ReadFile( dir + "/report.html" )
+    out := string(data)
+    for _, want := range []string{
+        "<h2>Summary</h2>", "<tr><td>Pages</td><td>2</td></tr>", `<table id="pages" class="sortable">`,
+        `<td class="bad">` + ts.URL + `/gone</td>`, `<a href="` + ts.URL + `/old">` + ts.URL + `/old</a><br>`,
+        ts.URL + "/old (301) &rarr; ", "&ndash; Home <small>(2 pages)</small></summary>", "<summary>docs <small>(1 pages)</small></summary>",
+        "New &lt;script&gt;alert(1)&lt;/script&gt;",
+    } {
+        if strings.Contains( out, want ) == false {
+            t.Fatalf("TestRunReport() failed: Expecting the report to contain %s, got:\n%s", want, out)
+        }
+    }
+    if strings.Contains( out, "<script>alert" ) || strings.Contains( out, " src=" ) || strings.Contains( out, "<link" ) {
+        t.Fatalf("TestRunReport() failed: Expecting no unescaped titles or external assets, got:\n%s", out)
+    }
+}
+
+
+// Unit test Run with the frontier, visited URLs and pages kept on disk
+func TestRunStorageDir(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        fmt.Fprintf( w, `<html><body><img src="%s.png"><a href="/a">a</a><a href="/b">b</a><a href="/a">a</a></body></html>`, r.URL.Path )
+    }))
+    defer ts.Close()
+
+    dir := t.TempDir()
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithStorageDir(dir),
+        petitcrawler.WithFilename( dir + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunStorageDir() Failed to create crawler. %s.", err)
+    }
+    defer c.Close()
+    if _, ok := c.Storage.(*petitcrawler.DiskStorage); ok == false {
+        t.Fatalf("TestRunStorageDir() failed: Expecting disk storage, got %T.", c.Storage)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunStorageDir() failed: %s", err)
+    }
+    if c.NumPages != 3 || c.Storage.Count( petitcrawler.SetVisited ) != 3 || c.Storage.Queued() != 0 {
+        t.Fatalf("TestRunStorageDir() failed: Expecting 3 pages, visited and none queued, got %d, %d and %d.", c.NumPages, c.Storage.Count( petitcrawler.SetVisited ), c.Storage.Queued())
+    }
+    out, _ := os.ReadFile( dir + "/sitemap.txt" )
+    if strings.Count( string(out), "Page URL: " ) != 3 {
+        t.Fatalf("TestRunStorageDir() failed: Expecting 3 pages printed, got:\n%s", out)
+    }
+
+    if _, err = petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithStorageDir( dir + "/missing" ) ); err == nil {
+        t.Fatalf("TestRunStorageDir() failed: Expecting missing storage directory to fail.")
+    }
+}
+
+
+// Unit test a crawl stopped by its page cap can be resumed from its state file, without fetching pages twice
+func TestResume(t *testing.T) {
+    var mu sync.Mutex
+    fetched := make( map[string]int )
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        mu.Lock()
+        fetched[r.URL.Path]++
+        mu.Unlock()
+        n := 0
+        fmt.Sscanf( r.URL.Path, "/%d", &n )
+        fmt.Fprintf( w, `<html><body><img src="/%d.png"><a href="/%d">a</a><a href="/%d">b</a></body></html>`, n, 2*n+1, 2*n+2 )
+    }))
+    defer ts.Close()
+
+    dir := t.TempDir()
+    state := dir + "/state.db"
+    if _, err := petitcrawler.NewSingleCrawler( ts.URL + "/0", petitcrawler.WithStorage( petitcrawler.NewMemoryStorage( petitcrawler.BreadthFirst, nil ) ),
+        petitcrawler.WithCheckpoint( state, time.Hour ) ); err == nil {
+        t.Fatalf("TestResume() failed: Expecting to fail with both a Storage and a state file.")
+    }
+    c, err := petitcrawler.NewSingleCrawler( ts.URL + "/0", petitcrawler.WithNumWorkers(1), petitcrawler.WithMaxPages(3),
+        petitcrawler.WithMaxDepth(2), petitcrawler.WithExclude("/6"),
+        petitcrawler.WithIgnoreRobots(true), petitcrawler.WithCheckpoint( state, time.Hour ), petitcrawler.WithFilename( dir + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestResume() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestResume() failed: %s", err)
+    }
+    if c.Reason != petitcrawler.StopPageCap {
+        t.Fatalf("TestResume() failed: Expecting page cap, got %s.", c.Reason)
+    }
+    var collected []string
+    c.Storage.Pages( func( p petitcrawler.Page ) error {
+        collected = append( collected, strings.TrimPrefix( p.MyUrl, ts.URL ) )
+        return nil
+    })
+    c.Close()
+
+    if _, err = petitcrawler.Resume( dir + "/missing.db" ); err == nil {
+        t.Fatalf("TestResume() failed: Expecting to fail without a state file.")
+    }
+    // What's crawled comes from the state file, not the options
+    c, err = petitcrawler.Resume( state, petitcrawler.WithNumWorkers(2), petitcrawler.WithMaxPages(20),
+        petitcrawler.WithIgnoreRobots(true), petitcrawler.WithFilename( dir + "/sitemap.txt" ), petitcrawler.WithMaxDepth(5),
+        petitcrawler.WithScope( petitcrawler.ScopeExactHost ) )
+    if err != nil {
+        t.Fatalf("TestResume() failed to resume: %s", err)
+    }
+    defer c.Close()
+    if c.NumPages != 3 || c.Site.String() != ts.URL + "/0" {
+        t.Fatalf("TestResume() failed: Expecting 3 pages of %s, got %d of %s.", ts.URL + "/0", c.NumPages, c.Site)
+    }
+    if c.MaxDepth != 2 || c.ScopeMode != petitcrawler.ScopeWWW || len(c.ExcludeRules) != 1 || len(c.IncludeRules) != 0 {
+        t.Fatalf("TestResume() failed: Expecting the settings of the state file, got depth %d, scope %d and rules %v, %v.",
+            c.MaxDepth, c.ScopeMode, c.IncludeRules, c.ExcludeRules)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestResume() failed: %s", err)
+    }
+
+    // A tree of depth 2 has 7 pages, less the excluded /6, the ones collected by the first run aren't fetched again
+    if c.NumPages != 6 || c.Reason != petitcrawler.StopFrontierExhausted {
+        t.Fatalf("TestResume() failed: Expecting 6 pages, got %d (%s).", c.NumPages, c.Reason)
+    }
+    mu.Lock()
+    defer mu.Unlock()
+    for _, path := range collected {
+        if fetched[path] != 1 {
+            t.Fatalf("TestResume() failed: %s fetched %d times.", path, fetched[path])
+        }
+    }
+}
+
+
+// Unit test Stop lets pages being crawled finish within the grace period, then ends the crawl as interrupted
+func TestRunStop(t *testing.T) {
+    started := make( chan string, 100 )
+    release := make( chan bool )
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        if r.URL.Path != "/" {
+            started <- r.URL.Path
+            select {
+                case <- release:
+                case <- r.Context().Done():
+                    return
+            }
+        }
+        fmt.Fprintf( w, `<html><body><img src="%s.png"><a href="/a">a</a><a href="/b">b</a><a href="/c">c</a><a href="/d">d</a></body></html>`, r.URL.Path )
+    }))
+    defer ts.Close()
+    defer close( release )
+
+    // Pages being crawled when Stop is called are released in the grace period, and collected
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithIgnoreRobots(true),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunStop() Failed to create crawler. %s.", err)
+    }
+    errs := make( chan error )
+    go func() { errs <- c.Run() }()
+    <- started
+    <- started
+    c.Stop( 5 * time.Second )
+    time.Sleep( 100 * time.Millisecond )
+    release <- true
+    release <- true
+    if err = <- errs; err != nil {
+        t.Fatalf("TestRunStop() failed: %s", err)
+    }
+    if c.Reason != petitcrawler.StopInterrupted || c.NumPages != 3 || c.Storage.Queued() != 2 {
+        t.Fatalf("TestRunStop() failed: Expecting interrupted with 3 pages and 2 queued, got %s with %d and %d.", c.Reason, c.NumPages, c.Storage.Queued())
+    }
+
+    // Pages still being crawled when the grace period ends are aborted
+    c, err = petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithIgnoreRobots(true),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunStop() Failed to create crawler. %s.", err)
+    }
+    go func() { errs <- c.Run() }()
+    <- started
+    <- started
+    t0 := time.Now()
+    c.Stop( 100 * time.Millisecond )
+    if err = <- errs; err != nil {
+        t.Fatalf("TestRunStop() failed: %s", err)
+    }
+    if c.Reason != petitcrawler.StopInterrupted || c.NumPages != 1 || time.Since(t0) > 2*time.Second {
+        t.Fatalf("TestRunStop() failed: Expecting interrupted with 1 page after the grace period, got %s with %d after %s.", c.Reason, c.NumPages, time.Since(t0))
+    }
+}
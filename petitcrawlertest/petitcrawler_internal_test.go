@@ -3,16 +3,29 @@ package petitcrawler_test
 
 import (
     "petitcrawler"
+    "context"
+    "crypto/tls"
+    "encoding/pem"
     "testing"
     "time"
     "os"
     "net/url"
+    "net/http"
+    "net/http/httptest"
     "golang.org/x/net/html"
+    "strings"
     "sync"
     "fmt"
 )
 
 
+// scopeFor creates the default scope for domain, nil if domain is bad
+func scopeFor( domain *url.URL ) *petitcrawler.Scope {
+    scope, _ := petitcrawler.NewScope( petitcrawler.ScopeWWW, domain )
+    return scope
+}
+
+
 // Unit test DomainCheck with many test cases
 func TestDomainCheck( t *testing.T) {
     d, err := url.Parse("http://google.com")
@@ -79,8 +92,9 @@ func TestCheckNodeBadDomain( t *testing.T) {
     var page petitcrawler.Page
     t0 := time.Now()
     domain, _ := url.Parse("http://")
-    uList := make( chan string )
-    err = petitcrawler.CheckNode( doc, uList, domain, &page, t0)
+    scope := scopeFor( domain )
+    uList := make( chan petitcrawler.Link )
+    err = petitcrawler.CheckNode( context.Background(), doc, uList, scope, domain, &page, t0)
     if err == nil {
         t.Fatalf("TestCheckNodeBadDomain() Failed: Expecting to fail on bad domain.")
     }
@@ -94,8 +108,9 @@ func TestCheckNodeBadPageStruct( t *testing.T) {
     var page *petitcrawler.Page
     t0 := time.Now()
     domain, _ := url.Parse("http://google.com")
-    uList := make( chan string )
-    err = petitcrawler.CheckNode( doc, uList, domain, page, t0)
+    scope := scopeFor( domain )
+    uList := make( chan petitcrawler.Link )
+    err = petitcrawler.CheckNode( context.Background(), doc, uList, scope, domain, page, t0)
     if err == nil {
         t.Fatalf("TestCheckNodeBadPageStruct() failed: Expecting to fail on bad page ptr.")
     }
@@ -108,8 +123,9 @@ func TestCheckNodeBadHtmlNode( t *testing.T) {
     var page petitcrawler.Page
     t0 := time.Now()
     domain, _ := url.Parse("http://google.com")
-    uList := make( chan string, 100 )
-    err := petitcrawler.CheckNode( doc, uList, domain, &page, t0)
+    scope := scopeFor( domain )
+    uList := make( chan petitcrawler.Link, 100 )
+    err := petitcrawler.CheckNode( context.Background(), doc, uList, scope, domain, &page, t0)
     if err != nil {
         t.Fatalf("TestCheckNodeBadHtmlNode() failed: %s. Expecting to succeed.", err)
     }
@@ -119,8 +135,9 @@ func TestCheckNodeBadHtmlNode( t *testing.T) {
 // Unit test Work bad domain
 func TestWorkBadDomain( t *testing.T) {
     domain, _ := url.Parse("http://")
-    uList := make( chan string, 100 )
-    _, err :=  petitcrawler.Work( "http://google.com/search", uList, domain)
+    scope := scopeFor( domain )
+    uList := make( chan petitcrawler.Link, 100 )
+    _, err :=  petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: "http://google.com/search" }, uList, scope )
     if err == nil {
         t.Fatalf("TestWorkBadDomain() failed: %s. Expecting to succeed.", err)
     }
@@ -130,8 +147,9 @@ func TestWorkBadDomain( t *testing.T) {
 // Unit test Work basic parse 
 func TestWorkGoodChan( t *testing.T) {
     domain, _ := url.Parse("http://google.com")
-    uList := make( chan string, 100 )
-    _, err :=  petitcrawler.Work( "http://google.com/search", uList, domain)
+    scope := scopeFor( domain )
+    uList := make( chan petitcrawler.Link, 100 )
+    _, err :=  petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: "http://google.com/search" }, uList, scope )
     if err != nil {
         t.Fatalf("TestWorkGoodChan() failed: %s. Expecting to succeed.", err)
     }
@@ -141,8 +159,9 @@ func TestWorkGoodChan( t *testing.T) {
 // Unit test Work returns on full/block channel
 func TestWorkBadChan(t *testing.T) {
     domain, _ := url.Parse("http://google.com")
-    uList := make( chan string )
-    _, err :=  petitcrawler.Work( "http://google.com/search", uList, domain)
+    scope := scopeFor( domain )
+    uList := make( chan petitcrawler.Link )
+    _, err :=  petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: "http://google.com/search" }, uList, scope )
     if err == nil {
         t.Fatalf("TestWorkBadChan() failed: %s. Expecting to fail on full channel.", err)
     }
@@ -152,8 +171,9 @@ func TestWorkBadChan(t *testing.T) {
 // Unit test Work bad link
 func TestWorkBadLink(t *testing.T) {
     domain, _ := url.Parse("http://google.com")
-    uList := make( chan string )
-    _, err :=  petitcrawler.Work( "brokenlink", uList, domain)
+    scope := scopeFor( domain )
+    uList := make( chan petitcrawler.Link )
+    _, err :=  petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: "brokenlink" }, uList, scope )
     if err == nil {
         t.Fatalf("TestWork() failed: %s. Expecting to fail on broken url.", err)
     }
@@ -163,15 +183,16 @@ func TestWorkBadLink(t *testing.T) {
 // Test completion on a full, blocked channel, and empty
 func TestWork(t *testing.T) {
     domain, _ := url.Parse("http://google.com")
+    scope := scopeFor( domain )
     
-    uList := make( chan string )
-    _, err :=  petitcrawler.Work( "http://google.com/search", uList, domain)
+    uList := make( chan petitcrawler.Link )
+    _, err :=  petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: "http://google.com/search" }, uList, scope )
     if err == nil {
         t.Fatalf("TestWork() failed: %s. Expecting to block on channel and exit", err)
     }
 
-    uList2 := make( chan string, 100)
-    _, err =  petitcrawler.Work( "http://google.com/search", uList2, domain)
+    uList2 := make( chan petitcrawler.Link, 100)
+    _, err =  petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: "http://google.com/search" }, uList2, scope )
     if err != nil {
         t.Fatalf("TestWork() failed: %s. Expecting to have enough space", err)
     }
@@ -181,19 +202,21 @@ func TestWork(t *testing.T) {
 // Unit test Worker exits normally given work
 func TestWorkerShutdown(t *testing.T) {
     numw := petitcrawler.MAX_WORKERS
-    urls := make( chan string, numw)
-    rurls := make( chan string, numw)
+    urls := make( chan petitcrawler.Link, numw)
+    rurls := make( chan petitcrawler.Link, numw)
     pages := make( chan petitcrawler.Page, numw)
+    done := make( chan petitcrawler.Fetch, numw)
     shutdown := make( chan bool, numw)
     domain, _ := url.Parse("http://hi.com")
+    scope := scopeFor( domain )
     var wg sync.WaitGroup
     for i:=0; i<numw; i++ {
         wg.Add(1)
-        go petitcrawler.Worker( i , urls, rurls, domain, pages, shutdown, &wg )
+        go petitcrawler.Worker( context.Background(), i , &petitcrawler.Fetcher{}, urls, rurls, scope, pages, done, shutdown, &wg )
     }
-    urls <- "http://hi.com"
-    urls <- "http://google.com"
-    urls <- "http://hello.com"
+    urls <- petitcrawler.Link{ URL: "http://hi.com" }
+    urls <- petitcrawler.Link{ URL: "http://google.com" }
+    urls <- petitcrawler.Link{ URL: "http://hello.com" }
     for i:=0; i<numw; i++ {
         shutdown <- true
     }
@@ -204,15 +227,17 @@ func TestWorkerShutdown(t *testing.T) {
 // Unit test Worker exits normally given no work
 func TestWorkerShutdownNoWork(t *testing.T) {
     numw := petitcrawler.MAX_WORKERS
-    urls := make( chan string, numw)
-    rurls := make( chan string, numw)
+    urls := make( chan petitcrawler.Link, numw)
+    rurls := make( chan petitcrawler.Link, numw)
     pages := make( chan petitcrawler.Page, numw)
+    done := make( chan petitcrawler.Fetch, numw)
     shutdown := make( chan bool, numw)
     domain, _ := url.Parse("http://hi.com")
+    scope := scopeFor( domain )
     var wg sync.WaitGroup
     for i:=0; i<numw; i++ {
         wg.Add(1)
-        go petitcrawler.Worker( i , urls, rurls, domain, pages, shutdown, &wg )
+        go petitcrawler.Worker( context.Background(), i , &petitcrawler.Fetcher{}, urls, rurls, scope, pages, done, shutdown, &wg )
     }
     for i:=0; i<numw; i++ {
         shutdown <- true
@@ -224,19 +249,21 @@ func TestWorkerShutdownNoWork(t *testing.T) {
 // Unit test Worker exits on blocked/full channel pages
 func TestWorkerShutdownBadChanPages(t *testing.T) {
     numw := petitcrawler.MAX_WORKERS
-    urls := make( chan string, numw)
-    rurls := make( chan string, numw)
+    urls := make( chan petitcrawler.Link, numw)
+    rurls := make( chan petitcrawler.Link, numw)
     pages := make( chan petitcrawler.Page)
+    done := make( chan petitcrawler.Fetch, numw)
     shutdown := make( chan bool, numw)
     domain, _ := url.Parse( "http://hi.com" )
+    scope := scopeFor( domain )
     var wg sync.WaitGroup
     for i:=0; i<numw; i++ {
         wg.Add(1)
-        go petitcrawler.Worker( i , urls, rurls, domain, pages, shutdown, &wg )
+        go petitcrawler.Worker( context.Background(), i , &petitcrawler.Fetcher{}, urls, rurls, scope, pages, done, shutdown, &wg )
     }
-    urls <- "http://hi.com"
-    urls <- "http://google.com"
-    urls <- "http://hello.com"
+    urls <- petitcrawler.Link{ URL: "http://hi.com" }
+    urls <- petitcrawler.Link{ URL: "http://google.com" }
+    urls <- petitcrawler.Link{ URL: "http://hello.com" }
     for i:=0; i<numw; i++ {
         shutdown <- true
     }
@@ -247,19 +274,21 @@ func TestWorkerShutdownBadChanPages(t *testing.T) {
 // Unit test Worker exits on blocked/full channel rurls
 func TestWorkerShutdownBadChanRurls(t *testing.T) {
     numw := petitcrawler.MAX_WORKERS
-    urls := make( chan string, numw)
-    rurls := make( chan string)
+    urls := make( chan petitcrawler.Link, numw)
+    rurls := make( chan petitcrawler.Link)
     pages := make( chan petitcrawler.Page, numw)
+    done := make( chan petitcrawler.Fetch, numw)
     shutdown := make( chan bool, numw)
     domain, _ := url.Parse( "http://hi.com" )
+    scope := scopeFor( domain )
     var wg sync.WaitGroup
     for i:=0; i<numw; i++ {
         wg.Add(1)
-        go petitcrawler.Worker( i , urls, rurls, domain, pages, shutdown, &wg )
+        go petitcrawler.Worker( context.Background(), i , &petitcrawler.Fetcher{}, urls, rurls, scope, pages, done, shutdown, &wg )
     }
-    urls <- "http://hi.com"
-    urls <- "http://google.com"
-    urls <- "http://hello.com"
+    urls <- petitcrawler.Link{ URL: "http://hi.com" }
+    urls <- petitcrawler.Link{ URL: "http://google.com" }
+    urls <- petitcrawler.Link{ URL: "http://hello.com" }
     for i:=0; i<numw; i++ {
         shutdown <- true
     }
@@ -270,15 +299,17 @@ func TestWorkerShutdownBadChanRurls(t *testing.T) {
 // Unit test Worker exits, all channels blocked
 func TestWorkerShutdownBadChanAll(t *testing.T) {
     numw := petitcrawler.MAX_WORKERS
-    urls := make( chan string, numw)
-    rurls := make( chan string)
+    urls := make( chan petitcrawler.Link, numw)
+    rurls := make( chan petitcrawler.Link)
     pages := make( chan petitcrawler.Page)
+    done := make( chan petitcrawler.Fetch, numw)
     shutdown := make( chan bool, numw)
     domain, _ := url.Parse("http://hi.com")
+    scope := scopeFor( domain )
     var wg sync.WaitGroup
     for i:=0; i<numw; i++ {
         wg.Add(1)
-        go petitcrawler.Worker( i , urls, rurls, domain, pages, shutdown, &wg )
+        go petitcrawler.Worker( context.Background(), i , &petitcrawler.Fetcher{}, urls, rurls, scope, pages, done, shutdown, &wg )
     }
     for i:=0; i<numw; i++ {
         shutdown <- true
@@ -302,40 +333,40 @@ func TestPagePrint(t *testing.T){
 
 // Unit test Run with bad crawler Site
 func TestRunBadSite(t *testing.T){
-    c, err := petitcrawler.New()
+    c, err := newCrawler()
     if err != nil {
         t.Fatalf( fmt.Sprintf("TestRunBadSite() Failed to create crawler. %s.", err))
     }
     c.Site = nil
-    err = petitcrawler.Run(c)
+    err = c.Run()
     if err == nil{
         t.Fatalf("TestRunBadSite() Failed: Expecting to quit on crawler with no Site.")
     }
 }
 
 
-// Unit test Run with bad crawler Sitemap
-func TestRunBadSitemap(t *testing.T){
-    c, err := petitcrawler.New()
+// Unit test Run with bad crawler Storage
+func TestRunBadStorage(t *testing.T){
+    c, err := newCrawler()
     if err != nil {
-        t.Fatalf( fmt.Sprintf("TestRunBadSitemap() Failed to create crawler. %s.", err))
+        t.Fatalf( fmt.Sprintf("TestRunBadStorage() Failed to create crawler. %s.", err))
     }
-    c.Sitemap = nil
-    err = petitcrawler.Run(c)
+    c.Storage = nil
+    err = c.Run()
     if err == nil{
-        t.Fatalf("TestRunBadSitemap() Failed: Expecting to quit on crawler with no Sitemap.")
+        t.Fatalf("TestRunBadStorage() Failed: Expecting to quit on crawler with no Storage.")
     }
 }
 
 
 // Unit test Run with bad crawler num workers <= 0
 func TestRunNegativeWorkers(t *testing.T){
-    c, err := petitcrawler.New()
+    c, err := newCrawler()
     if err != nil {
         t.Fatalf( fmt.Sprintf("TestRunBadNegativeWorkers() Failed to create crawler. %s.", err))
     }
     c.NumWorkers = -2000
-    err = petitcrawler.Run(c)
+    err = c.Run()
     if err == nil{
         t.Fatalf("TestRunNegativeWorkers() Failed: Expecting to quit on crawler with negative # workers.")
     }
@@ -344,12 +375,12 @@ func TestRunNegativeWorkers(t *testing.T){
 
 // Unit test Run with bad crawler no filename
 func TestRunBadFilename(t *testing.T){
-    c, err := petitcrawler.New()
+    c, err := newCrawler()
     if err != nil {
         t.Fatalf( fmt.Sprintf("TestRunBadFilename() Failed to create crawler. %s.", err))
     }
     c.Filename = ""
-    err = petitcrawler.Run(c)
+    err = c.Run()
     if err == nil{
         t.Fatalf("TestRunBadFilename() Failed: Expecting to quit on crawler with no filename.")
     }
@@ -358,7 +389,7 @@ func TestRunBadFilename(t *testing.T){
 
 // Unit test SingleCrawler.Print
 func TestPrint(t *testing.T) {
-    c, err := petitcrawler.New()
+    c, err := newCrawler()
     if err != nil {
         t.Fatalf( fmt.Sprintf("TestPrint() Failed to create crawler. %s.", err))
     }
@@ -367,3 +398,656 @@ func TestPrint(t *testing.T) {
         t.Fatalf( fmt.Sprintf("TestPrint() Failed to print crawler sitemap. %s.", err))
     }
 }
+
+
+// Unit test Work uses the client it is given, and its timeout
+func TestWorkClient(t *testing.T) {
+    release := make( chan bool )
+    ts := httptest.NewTLSServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        if r.URL.Path == "/slow" {
+            select {
+                case <- release:
+                case <- r.Context().Done():
+            }
+        }
+        fmt.Fprint( w, `<html><body><a href="/next">next</a></body></html>` )
+    }))
+    defer ts.Close()
+    defer close(release)
+    domain, _ := url.Parse( ts.URL )
+    scope := scopeFor( domain )
+
+    // The default client doesn't trust the test server's certificate
+    uList := make( chan petitcrawler.Link, 100 )
+    if _, err := petitcrawler.Work( context.Background(), &petitcrawler.Fetcher{ Client: http.DefaultClient }, petitcrawler.Link{ URL: ts.URL }, uList, scope ); err == nil {
+        t.Fatalf("TestWorkClient() failed: Expecting to fail on untrusted certificate.")
+    }
+
+    client := ts.Client()
+    p, err := petitcrawler.Work( context.Background(), &petitcrawler.Fetcher{ Client: client }, petitcrawler.Link{ URL: ts.URL }, uList, scope )
+    if err != nil {
+        t.Fatalf("TestWorkClient() failed: %s. Expecting to succeed with test server client.", err)
+    }
+    if len(p.BabyUrls) != 1 {
+        t.Fatalf("TestWorkClient() failed: Expecting 1 url, got %d.", len(p.BabyUrls))
+    }
+
+    client.Timeout = 100*time.Millisecond
+    t0 := time.Now()
+    if _, err = petitcrawler.Work( context.Background(), &petitcrawler.Fetcher{ Client: client }, petitcrawler.Link{ URL: ts.URL + "/slow" }, uList, scope ); err == nil {
+        t.Fatalf("TestWorkClient() failed: Expecting to time out.")
+    }
+    if time.Since(t0) > 2*time.Second {
+        t.Fatalf("TestWorkClient() failed: took %s to time out.", time.Since(t0))
+    }
+}
+
+
+// Unit test NewSingleCrawler sets up the http client from options
+func TestNewSingleCrawlerClientOptions(t *testing.T) {
+    c, err := petitcrawler.NewSingleCrawler( "http://example.com", petitcrawler.WithTimeout(3*time.Second),
+        petitcrawler.WithProxy("http://proxy.internal:3128"), petitcrawler.WithMaxIdleConns(7) )
+    if err != nil {
+        t.Fatalf("TestNewSingleCrawlerClientOptions() failed: %s", err)
+    }
+    if c.Client == nil || c.Client.Timeout != 3*time.Second {
+        t.Fatalf("TestNewSingleCrawlerClientOptions() failed: client timeout not set.")
+    }
+    transport, ok := c.Client.Transport.(*http.Transport)
+    if ok == false || transport.MaxIdleConns != 7 {
+        t.Fatalf("TestNewSingleCrawlerClientOptions() failed: transport not configured.")
+    }
+    req, _ := http.NewRequest( "GET", "http://example.com", nil )
+    if proxy, _ := transport.Proxy(req); proxy == nil || proxy.Host != "proxy.internal:3128" {
+        t.Fatalf("TestNewSingleCrawlerClientOptions() failed: proxy not configured, got %v.", proxy)
+    }
+
+    mine := &http.Client{}
+    c, err = petitcrawler.NewSingleCrawler( "http://example.com", petitcrawler.WithHTTPClient(mine) )
+    if err != nil || c.Client != mine {
+        t.Fatalf("TestNewSingleCrawlerClientOptions() failed: expecting to use the given client.")
+    }
+    if _, err = petitcrawler.NewSingleCrawler( "http://example.com", petitcrawler.WithHTTPClient(mine),
+        petitcrawler.WithProxy("http://proxy.internal:3128") ); err == nil {
+        t.Fatalf("TestNewSingleCrawlerClientOptions() failed: expecting to fail on client and proxy.")
+    }
+    if _, err = petitcrawler.NewSingleCrawler( "http://example.com", petitcrawler.WithRootCAs("no-such-file.pem") ); err == nil {
+        t.Fatalf("TestNewSingleCrawlerClientOptions() failed: expecting to fail on missing CA file.")
+    }
+}
+
+
+// Unit test the TLS options give the same config in any order, and leave the caller's config alone
+func TestNewSingleCrawlerTLSOptions(t *testing.T) {
+    ts := httptest.NewTLSServer( http.NotFoundHandler() )
+    defer ts.Close()
+    caFile := t.TempDir() + "/ca.pem"
+    if err := os.WriteFile( caFile, pem.EncodeToMemory( &pem.Block{ Type: "CERTIFICATE", Bytes: ts.Certificate().Raw } ), 0644 ); err != nil {
+        t.Fatalf("TestNewSingleCrawlerTLSOptions() failed: %s", err)
+    }
+
+    for i := 0; i < 2; i++ {
+        mine := &tls.Config{ ServerName: "example.com" }
+        options := []petitcrawler.Option{ petitcrawler.WithTLSConfig(mine), petitcrawler.WithRootCAs(caFile),
+            petitcrawler.WithMinTLSVersion(tls.VersionTLS12) }
+        if i == 1 {
+            options = []petitcrawler.Option{ options[1], options[2], options[0] }
+        }
+
+        c, err := petitcrawler.NewSingleCrawler( "http://example.com", options... )
+        if err != nil {
+            t.Fatalf("TestNewSingleCrawlerTLSOptions() failed: %s", err)
+        }
+        config := c.Client.Transport.(*http.Transport).TLSClientConfig
+        if config == nil || config.ServerName != "example.com" || config.RootCAs == nil || config.MinVersion != tls.VersionTLS12 {
+            t.Fatalf("TestNewSingleCrawlerTLSOptions() failed: order %d lost an option, got %+v.", i, config)
+        }
+        if mine.RootCAs != nil || mine.MinVersion != 0 {
+            t.Fatalf("TestNewSingleCrawlerTLSOptions() failed: order %d changed the caller's config.", i)
+        }
+        config.ServerName = ""
+        if resp, err := c.Client.Get( ts.URL ); err != nil {
+            t.Fatalf("TestNewSingleCrawlerTLSOptions() failed: order %d doesn't trust the CA, %s.", i, err)
+        } else {
+            resp.Body.Close()
+        }
+    }
+}
+
+
+// Unit test Limiter spaces out requests after a burst, and keeps the minimum delay
+func TestLimiter(t *testing.T) {
+    ctx := context.Background()
+
+    limiter := petitcrawler.NewLimiter( 50, 3, 0 )
+    t0 := time.Now()
+    for i := 0; i < 3; i++ {
+        limiter.Wait(ctx)
+    }
+    if time.Since(t0) > 15*time.Millisecond {
+        t.Fatalf("TestLimiter() failed: burst of 3 should not wait, took %s.", time.Since(t0))
+    }
+    for i := 0; i < 5; i++ {
+        limiter.Wait(ctx)
+    }
+    if time.Since(t0) < 90*time.Millisecond {
+        t.Fatalf("TestLimiter() failed: 5 requests past the burst at 50/s should take ~100ms, took %s.", time.Since(t0))
+    }
+
+    limiter = petitcrawler.NewLimiter( 0, 0, 30*time.Millisecond )
+    t0 = time.Now()
+    for i := 0; i < 4; i++ {
+        limiter.Wait(ctx)
+    }
+    if time.Since(t0) < 90*time.Millisecond {
+        t.Fatalf("TestLimiter() failed: min delay of 30ms not kept, 4 requests took %s.", time.Since(t0))
+    }
+
+    limiter.Backoff( 100*time.Millisecond )
+    t0 = time.Now()
+    limiter.Wait(ctx)
+    if time.Since(t0) < 90*time.Millisecond {
+        t.Fatalf("TestLimiter() failed: backoff not respected, waited %s.", time.Since(t0))
+    }
+
+    cancelled, cancel := context.WithCancel( ctx )
+    cancel()
+    limiter.Backoff( time.Minute )
+    if err := limiter.Wait(cancelled); err == nil {
+        t.Fatalf("TestLimiter() failed: Expecting to stop waiting on cancelled context.")
+    }
+}
+
+
+// Unit test Work pauses the host's limiter on a 429 with Retry-After
+func TestWorkRetryAfter(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        w.Header().Set( "Retry-After", "1" )
+        w.WriteHeader( http.StatusTooManyRequests )
+    }))
+    defer ts.Close()
+    domain, _ := url.Parse( ts.URL )
+    scope := scopeFor( domain )
+
+    fetcher := &petitcrawler.Fetcher{ Limiters: petitcrawler.NewHostLimiter( 0, 0, 0 ), Retry: &petitcrawler.RetryPolicy{ MaxAttempts: 1 } }
+    if _, err := petitcrawler.Work( context.Background(), fetcher, petitcrawler.Link{ URL: ts.URL }, make( chan petitcrawler.Link, 10 ), scope ); err == nil {
+        t.Fatalf("TestWorkRetryAfter() failed: Expecting to fail on 429.")
+    }
+    t0 := time.Now()
+    fetcher.Limiters.For( "other.example.com" ).Wait( context.Background() )
+    if time.Since(t0) > 100*time.Millisecond {
+        t.Fatalf("TestWorkRetryAfter() failed: Expecting other hosts not to wait, waited %s.", time.Since(t0))
+    }
+    fetcher.Limiters.For( domain.Host ).Wait( context.Background() )
+    if time.Since(t0) < 900*time.Millisecond {
+        t.Fatalf("TestWorkRetryAfter() failed: Expecting to wait ~1s after Retry-After, waited %s.", time.Since(t0))
+    }
+}
+
+
+// Unit test Work retries server errors, but not a 404, and records every attempt
+func TestWorkRetry(t *testing.T) {
+    var mu sync.Mutex
+    hits := make( map[string]int )
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        mu.Lock()
+        hits[r.URL.Path]++
+        n := hits[r.URL.Path]
+        mu.Unlock()
+        if r.URL.Path == "/missing" {
+            w.WriteHeader( http.StatusNotFound )
+            return
+        }
+        if r.URL.Path == "/flaky" && n < 3 {
+            w.WriteHeader( http.StatusBadGateway )
+            return
+        }
+        if r.URL.Path == "/slow" {
+            select {
+                case <- time.After( 500*time.Millisecond ):
+                case <- r.Context().Done():
+                    return
+            }
+        }
+        fmt.Fprint( w, `<html><body></body></html>` )
+    }))
+    defer ts.Close()
+    domain, _ := url.Parse( ts.URL )
+    scope := scopeFor( domain )
+
+    policy := petitcrawler.DEFAULT_RETRY_POLICY
+    policy.BaseDelay = 10*time.Millisecond
+    fetcher := &petitcrawler.Fetcher{ Retry: &policy }
+    uList := make( chan petitcrawler.Link, 10 )
+
+    p, err := petitcrawler.Work( context.Background(), fetcher, petitcrawler.Link{ URL: ts.URL + "/flaky" }, uList, scope )
+    if err != nil {
+        t.Fatalf("TestWorkRetry() failed: %s. Expecting to succeed on the 3rd attempt.", err)
+    }
+    if len(p.Attempts) != 3 || p.Attempts[0].Status != 502 || p.Attempts[0].Retryable == false || p.Attempts[2].Status != 200 {
+        t.Fatalf("TestWorkRetry() failed: Expecting 3 recorded attempts, got %+v.", p.Attempts)
+    }
+
+    p, err = petitcrawler.Work( context.Background(), fetcher, petitcrawler.Link{ URL: ts.URL + "/missing" }, uList, scope )
+    if err == nil || len(p.Attempts) != 1 || p.Attempts[0].Retryable {
+        t.Fatalf("TestWorkRetry() failed: Expecting a 404 to fail once without retrying, got %+v.", p.Attempts)
+    }
+
+    policy.MaxAttempts = 2
+    mu.Lock()
+    hits["/flaky"] = 0
+    mu.Unlock()
+    p, err = petitcrawler.Work( context.Background(), fetcher, petitcrawler.Link{ URL: ts.URL + "/flaky" }, uList, scope )
+    if err == nil || len(p.Attempts) != 2 || p.Attempts[1].Retryable == false {
+        t.Fatalf("TestWorkRetry() failed: Expecting to give up after 2 retryable attempts, got %+v.", p.Attempts)
+    }
+
+    // A request slower than the client's timeout is retried
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithTimeout(50*time.Millisecond) )
+    if err != nil {
+        t.Fatalf("TestWorkRetry() Failed to create crawler. %s.", err)
+    }
+    fetcher.Client = c.Client
+    p, err = petitcrawler.Work( context.Background(), fetcher, petitcrawler.Link{ URL: ts.URL + "/slow" }, uList, scope )
+    if err == nil || len(p.Attempts) != 2 || p.Attempts[0].Retryable == false {
+        t.Fatalf("TestWorkRetry() failed: Expecting a client timeout to be retried, got %+v.", p.Attempts)
+    }
+}
+
+
+// Unit test RetryPolicy backoff grows exponentially up to MaxDelay
+func TestRetryPolicyBackoff(t *testing.T) {
+    policy := petitcrawler.RetryPolicy{ MaxAttempts: 5, BaseDelay: 100*time.Millisecond, MaxDelay: time.Second }
+    expect := []time.Duration{ 100*time.Millisecond, 200*time.Millisecond, 400*time.Millisecond, 800*time.Millisecond, time.Second, time.Second }
+    for i, d := range expect {
+        if got := policy.Backoff(i+1); got != d {
+            t.Fatalf("TestRetryPolicyBackoff() failed: attempt %d should wait %s, got %s.", i+1, d, got)
+        }
+    }
+    uncapped := petitcrawler.RetryPolicy{ MaxAttempts: 5, BaseDelay: 100*time.Millisecond }
+    if got := uncapped.Backoff(4); got != 800*time.Millisecond {
+        t.Fatalf("TestRetryPolicyBackoff() failed: without MaxDelay attempt 4 should wait %s, got %s.", 800*time.Millisecond, got)
+    }
+    policy.Jitter = 0.5
+    for i := 0; i < 100; i++ {
+        if got := policy.Backoff(2); got < 100*time.Millisecond || got > 200*time.Millisecond {
+            t.Fatalf("TestRetryPolicyBackoff() failed: jittered wait %s out of range.", got)
+        }
+    }
+}
+
+
+// Unit test Work resolves relative links against the page URL, after redirects, and <base href>
+func TestWorkRelativeLinks(t *testing.T) {
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/old/page":
+                http.Redirect( w, r, "/docs/guide/intro", http.StatusMovedPermanently )
+            case "/docs/guide/intro":
+                fmt.Fprint( w, `<html><body><a href="../about">a</a><a href="./next">n</a><a href="/top">t</a><a href="?page=2">q</a><a href="mailto:me@example.com">m</a></body></html>` )
+            case "/based":
+                fmt.Fprint( w, `<html><head><base href="/root/sub/"></head><body><a href="child">c</a></body></html>` )
+        }
+    }))
+    defer ts.Close()
+    domain, _ := url.Parse( ts.URL )
+    scope := scopeFor( domain )
+
+    uList := make( chan petitcrawler.Link, 10 )
+    p, err := petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: ts.URL + "/old/page" }, uList, scope )
+    if err != nil {
+        t.Fatalf("TestWorkRelativeLinks() failed: %s", err)
+    }
+    expect := []string{ ts.URL + "/docs/about", ts.URL + "/docs/guide/next", ts.URL + "/top", ts.URL + "/docs/guide/intro?page=2" }
+    if fmt.Sprint(p.BabyUrls) != fmt.Sprint(expect) {
+        t.Fatalf("TestWorkRelativeLinks() failed: Expecting %v, got %v.", expect, p.BabyUrls)
+    }
+
+    p, err = petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: ts.URL + "/based" }, uList, scope )
+    if err != nil {
+        t.Fatalf("TestWorkRelativeLinks() failed: %s", err)
+    }
+    if len(p.BabyUrls) != 1 || p.BabyUrls[0] != ts.URL + "/root/sub/child" {
+        t.Fatalf("TestWorkRelativeLinks() failed: Expecting link resolved against <base href>, got %v.", p.BabyUrls)
+    }
+}
+
+
+// Unit test Work records the response's status, final URL, headers and timing, and the page's metadata
+func TestWorkMetadata(t *testing.T) {
+    body := `<html lang="fr"><head><title>
+        Le   guide</title><meta name="Description" content=" All about it "><link rel="canonical" href="/guide">
+        <title>Second</title></head><body><a href="/a">a</a></body></html>`
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        if r.URL.Path == "/old" {
+            http.Redirect( w, r, "/guide?ref=1", http.StatusFound )
+            return
+        }
+        w.Header().Set( "Content-Type", "text/html; charset=utf-8" )
+        w.Header().Set( "ETag", `"v1"` )
+        w.Header().Set( "X-Secret", "not recorded" )
+        fmt.Fprint( w, body )
+    }))
+    defer ts.Close()
+    domain, _ := url.Parse( ts.URL )
+
+    t0 := time.Now()
+    p, err := petitcrawler.Work( context.Background(), nil, petitcrawler.Link{ URL: ts.URL + "/old", Depth: 2 }, make( chan petitcrawler.Link, 10 ), scopeFor( domain ) )
+    if err != nil {
+        t.Fatalf("TestWorkMetadata() failed: %s", err)
+    }
+    if p.Status != 200 || p.FinalURL != ts.URL + "/guide?ref=1" || p.ContentType != "text/html; charset=utf-8" || p.ContentLength != int64(len(body)) || p.Depth != 2 {
+        t.Fatalf("TestWorkMetadata() failed: Wrong response details %d, %s, %s, %d, depth %d.", p.Status, p.FinalURL, p.ContentType, p.ContentLength, p.Depth)
+    }
+    if p.FetchedAt.Before(t0) || p.ResponseTime <= 0 || p.ResponseTime > time.Since(t0) {
+        t.Fatalf("TestWorkMetadata() failed: Wrong timing, fetched at %s in %s.", p.FetchedAt, p.ResponseTime)
+    }
+    if len(p.Redirects) != 1 || p.Redirects[0].URL != ts.URL + "/old" || p.Redirects[0].Status != http.StatusFound {
+        t.Fatalf("TestWorkMetadata() failed: Expecting one redirect from /old, got %+v.", p.Redirects)
+    }
+    if len(p.Headers) != 1 || p.Headers["Etag"] != `"v1"` {
+        t.Fatalf("TestWorkMetadata() failed: Expecting only the ETag header recorded, got %v.", p.Headers)
+    }
+    if p.Title != "Le guide" || p.Description != "All about it" || p.Canonical != ts.URL + "/guide" || p.Lang != "fr" {
+        t.Fatalf("TestWorkMetadata() failed: Wrong metadata %q, %q, %q, %q.", p.Title, p.Description, p.Canonical, p.Lang)
+    }
+}
+
+
+// Unit test Normalizer with the default rules, and index and trailing slash folding
+func TestNormalize(t *testing.T) {
+    n := petitcrawler.DEFAULT_NORMALIZER
+    cases := []struct {
+        in string
+        out string
+    }{
+        { "http://site.com/a", "http://site.com/a" },
+        { "http://site.com/a/", "http://site.com/a/" },
+        { "HTTP://SITE.com/a#top", "http://site.com/a" },
+        { "http://site.com/a?utm_source=x", "http://site.com/a" },
+        { "http://site.com:80/a?b=2&a=1&gclid=z", "http://site.com/a?a=1&b=2" },
+        { "https://site.com:443", "https://site.com/" },
+        { "https://site.com:8443/", "https://site.com:8443/" },
+        { "http://site.com/%7euser/%c3%a9", "http://site.com/~user/%C3%A9" },
+        { "http://site.com/a%2fb", "http://site.com/a%2Fb" },
+        { "http://site.com/docs/index.html", "http://site.com/docs/index.html" },
+    }
+    for i, c := range cases {
+        out, err := n.Normalize( c.in )
+        if err != nil || out != c.out {
+            t.Fatalf("TestNormalize() Failed: Test case %d, %s should be %s, got %s (%v).", i+1, c.in, c.out, out, err)
+        }
+    }
+
+    n.FoldTrailingSlash = true
+    if out, _ := n.Normalize("http://site.com/a/"); out != "http://site.com/a" {
+        t.Fatalf("TestNormalize() Failed: Expecting trailing slash folded, got %s.", out)
+    }
+    n.FoldIndex = true
+    n.StripFragment = false
+    if out, _ := n.Normalize("http://site.com/docs/index.html#x"); out != "http://site.com/docs#x" {
+        t.Fatalf("TestNormalize() Failed: Expecting index.html folded and fragment kept, got %s.", out)
+    }
+    if _, err := n.Normalize("/relative"); err == nil {
+        t.Fatalf("TestNormalize() Failed: Expecting to fail on relative URL.")
+    }
+}
+
+
+// Unit test DomainCheck leaves the URL alone, and Scope modes
+func TestScope(t *testing.T) {
+    site, _ := url.Parse("http://web.example.co.uk/start")
+    if err := petitcrawler.DomainCheck(site); err != nil || site.Host != "web.example.co.uk" {
+        t.Fatalf("TestScope() Failed: DomainCheck changed the host to %s.", site.Host)
+    }
+
+    cases := []struct {
+        mode petitcrawler.ScopeMode
+        link string
+        in bool
+    }{
+        { petitcrawler.ScopeExactHost, "http://web.example.co.uk/a", true },
+        { petitcrawler.ScopeExactHost, "https://WEB.example.co.uk/a", true },
+        { petitcrawler.ScopeExactHost, "http://www.web.example.co.uk/a", false },
+        { petitcrawler.ScopeExactHost, "mailto:me@web.example.co.uk", false },
+        { petitcrawler.ScopeWWW, "http://www.web.example.co.uk/a", true },
+        { petitcrawler.ScopeWWW, "http://eb.example.co.uk/a", false },
+        { petitcrawler.ScopeWWW, "http://docs.example.co.uk/a", false },
+        { petitcrawler.ScopeSubdomains, "http://docs.example.co.uk/a", true },
+        { petitcrawler.ScopeSubdomains, "http://example.co.uk/a", true },
+        { petitcrawler.ScopeSubdomains, "http://other.co.uk/a", false },
+        { petitcrawler.ScopeSubdomains, "http://badexample.co.uk/a", false },
+        { petitcrawler.ScopeAllowList, "http://cdn.partner.com/a", true },
+        { petitcrawler.ScopeAllowList, "http://web.example.co.uk/a", true },
+        { petitcrawler.ScopeAllowList, "http://docs.example.co.uk/a", false },
+    }
+    for i, c := range cases {
+        scope, err := petitcrawler.NewScope( c.mode, site, "cdn.partner.com" )
+        if err != nil {
+            t.Fatalf("TestScope() Failed: %s.", err)
+        }
+        u, _ := url.Parse( c.link )
+        if scope.InScope(u) != c.in {
+            t.Fatalf("TestScope() Failed: Test case %d, %s should be in scope=%t.", i+1, c.link, c.in)
+        }
+    }
+
+    if _, err := petitcrawler.NewScope( petitcrawler.ScopeWWW, &url.URL{} ); err == nil {
+        t.Fatalf("TestScope() Failed: Expecting to fail on URL without a host.")
+    }
+}
+
+
+// Unit test include/exclude rules on a Scope
+func TestScopeRules(t *testing.T) {
+    site, _ := url.Parse("http://example.com/docs/")
+    scope, _ := petitcrawler.NewScope( petitcrawler.ScopeWWW, site )
+    for _, r := range []string{ "/docs/", "glob:/blog/*/2017/*" } {
+        rule, err := petitcrawler.ParseRule(r)
+        if err != nil {
+            t.Fatalf("TestScopeRules() Failed: %s.", err)
+        }
+        scope.Include = append( scope.Include, rule )
+    }
+    for _, r := range []string{ "/docs/search", "glob:/docs/calendar/*", "regexp:[?&]sessionid=" } {
+        rule, err := petitcrawler.ParseRule(r)
+        if err != nil {
+            t.Fatalf("TestScopeRules() Failed: %s.", err)
+        }
+        scope.Exclude = append( scope.Exclude, rule )
+    }
+
+    cases := []struct {
+        link string
+        reason string
+    }{
+        { "http://example.com/docs/intro", "" },
+        { "http://example.com/blog/go/2017/june", "" },
+        { "http://example.com/blog/go/2018/june", "no include rule matched" },
+        { "http://example.com/about", "no include rule matched" },
+        { "http://example.com/docs/search?q=x", "exclude prefix:/docs/search" },
+        { "http://example.com/docs/calendar/2017/06", "exclude glob:/docs/calendar/*" },
+        { "http://example.com/docs/intro?a=1&sessionid=2", "exclude regexp:[?&]sessionid=" },
+        { "http://other.com/docs/intro", "out of scope" },
+    }
+    for i, c := range cases {
+        u, _ := url.Parse( c.link )
+        ok, reason := scope.Allowed(u)
+        if ok != (c.reason == "") || reason != c.reason {
+            t.Fatalf("TestScopeRules() Failed: Test case %d, %s should be rejected for '%s', got %t '%s'.", i+1, c.link, c.reason, ok, reason)
+        }
+    }
+
+    if _, err := petitcrawler.ParseRule("regexp:(unclosed"); err == nil {
+        t.Fatalf("TestScopeRules() Failed: Expecting to fail on bad regular expression.")
+    }
+}
+
+
+// Unit test the Frontier hands out links in each strategy's order
+func TestFrontier(t *testing.T) {
+    links := []petitcrawler.Link{
+        { URL: "http://site.com/a", Depth: 1 },
+        { URL: "http://site.com/a/b/c", Depth: 2 },
+        { URL: "http://site.com/d", Depth: 1 },
+        { URL: "http://site.com/e/f", Depth: 3 },
+        { URL: "http://site.com/", Depth: 0 },
+    }
+    cases := []struct {
+        strategy petitcrawler.Strategy
+        order []string
+    }{
+        { petitcrawler.BreadthFirst, []string{ "/", "/a", "/d", "/a/b/c", "/e/f" } },
+        { petitcrawler.DepthFirst, []string{ "/", "/e/f", "/d", "/a/b/c", "/a" } },
+        { petitcrawler.BestFirst, []string{ "/a", "/d", "/", "/e/f", "/a/b/c" } },
+    }
+    for _, c := range cases {
+        f := petitcrawler.NewFrontier( c.strategy, nil )
+        for _, l := range links {
+            f.Push(l)
+        }
+        if peek, _ := f.Peek(); peek.URL != "http://site.com" + c.order[0] {
+            t.Fatalf("TestFrontier() failed: strategy %d should peek %s, got %s.", c.strategy, c.order[0], peek.URL)
+        }
+        for _, want := range c.order {
+            l, ok := f.Pop()
+            if ok == false || l.URL != "http://site.com" + want {
+                t.Fatalf("TestFrontier() failed: strategy %d expecting %s next, got %s.", c.strategy, want, l.URL)
+            }
+        }
+        if _, ok := f.Pop(); ok || f.Len() != 0 {
+            t.Fatalf("TestFrontier() failed: strategy %d expecting empty frontier.", c.strategy)
+        }
+    }
+
+    // A custom score, preferring the longest URLs
+    f := petitcrawler.NewFrontier( petitcrawler.BestFirst, func( l petitcrawler.Link ) float64 { return float64(len(l.URL)) } )
+    for _, l := range links {
+        f.Push(l)
+    }
+    if l, _ := f.Pop(); l.URL != "http://site.com/a/b/c" {
+        t.Fatalf("TestFrontier() failed: custom score expecting /a/b/c first, got %s.", l.URL)
+    }
+}
+
+
+// Unit test DiskStorage orders its frontier like Frontier, and keeps its state when reopened
+func TestDiskStorage(t *testing.T) {
+    path := t.TempDir() + "/crawl.db"
+    links := []petitcrawler.Link{
+        { URL: "http://site.com/a/b", Depth: 2 },
+        { URL: "http://site.com/a", Depth: 1 },
+        { URL: "http://site.com/", Depth: 0 },
+    }
+    for _, strategy := range []petitcrawler.Strategy{ petitcrawler.BreadthFirst, petitcrawler.DepthFirst, petitcrawler.BestFirst } {
+        store, err := petitcrawler.NewDiskStorage( t.TempDir() + "/order.db", strategy, nil )
+        if err != nil {
+            t.Fatalf("TestDiskStorage() failed: %s", err)
+        }
+        mem := petitcrawler.NewFrontier( strategy, nil )
+        for _, l := range links {
+            store.Push(l)
+            mem.Push(l)
+        }
+        for mem.Len() > 0 {
+            want, _ := mem.Pop()
+            got, ok, err := store.Pop()
+            if ok == false || err != nil || got != want {
+                t.Fatalf("TestDiskStorage() failed: strategy %d expecting %v, got %v (%s).", strategy, want, got, err)
+            }
+        }
+        store.Close()
+    }
+
+    store, err := petitcrawler.NewDiskStorage( path, petitcrawler.BreadthFirst, nil )
+    if err != nil {
+        t.Fatalf("TestDiskStorage() failed: %s", err)
+    }
+    for _, l := range links {
+        store.Push(l)
+    }
+    store.Pop()
+    if added, _ := store.Mark( petitcrawler.SetVisited, "http://site.com/" ); added == false {
+        t.Fatalf("TestDiskStorage() failed: Expecting new key to be added.")
+    }
+    if added, _ := store.Mark( petitcrawler.SetVisited, "http://site.com/" ); added {
+        t.Fatalf("TestDiskStorage() failed: Expecting key to be added once.")
+    }
+    store.AddPage( petitcrawler.Page{ MyUrl: "http://site.com/", Assets: []string{ "a.png" } } )
+    store.AddPage( petitcrawler.Page{ MyUrl: "http://site.com/a", Depth: 1 } )
+    if err = store.Close(); err != nil {
+        t.Fatalf("TestDiskStorage() failed: %s", err)
+    }
+
+    store, err = petitcrawler.NewDiskStorage( path, petitcrawler.BreadthFirst, nil )
+    if err != nil {
+        t.Fatalf("TestDiskStorage() failed to reopen: %s", err)
+    }
+    defer store.Close()
+    if store.Queued() != 2 || store.Count( petitcrawler.SetVisited ) != 1 {
+        t.Fatalf("TestDiskStorage() failed: Expecting 2 queued and 1 visited, got %d and %d.", store.Queued(), store.Count( petitcrawler.SetVisited ))
+    }
+    if seen, _ := store.Seen( petitcrawler.SetVisited, "http://site.com/" ); seen == false {
+        t.Fatalf("TestDiskStorage() failed: Expecting visited URL to be kept.")
+    }
+    if l, _, _ := store.Peek(); l.URL != "http://site.com/a" {
+        t.Fatalf("TestDiskStorage() failed: Expecting /a next, got %s.", l.URL)
+    }
+    var urls []string
+    store.Pages( func( p petitcrawler.Page ) error {
+        urls = append( urls, p.MyUrl )
+        return nil
+    })
+    if fmt.Sprint(urls) != "[http://site.com/ http://site.com/a]" {
+        t.Fatalf("TestDiskStorage() failed: Expecting pages in order, got %v.", urls)
+    }
+}
+
+
+// Unit test VisibleText skips scripts, styles and markup, and collapses whitespace
+func TestVisibleText(t *testing.T) {
+    doc, err := html.Parse( strings.NewReader( `<html><head><title>T</title><script>var x;</script></head>
+        <body><h1>Hello,
+        world</h1><style>h1 {}</style><p>one <b>two</b></p><noscript>no</noscript></body></html>` ) )
+    if err != nil {
+        t.Fatalf("TestVisibleText() Failed to parse. %s.", err)
+    }
+    if text := petitcrawler.VisibleText( doc ); text != "Hello, world one two" {
+        t.Fatalf("TestVisibleText() failed: got %q.", text)
+    }
+}
+
+
+// Unit test SimHash puts near identical texts a few bits apart, and different texts far apart
+func TestSimHash(t *testing.T) {
+    var words, other []string
+    for i := 0; i < 200; i++ {
+        words = append( words, fmt.Sprintf("word%d", i) )
+        other = append( other, fmt.Sprintf("other%d", i) )
+    }
+    text := strings.Join( words, " " )
+    edited := strings.Replace( text, "word100 ", "", 1 ) + " Print this page"
+
+    if d := petitcrawler.HammingDistance( petitcrawler.SimHash(text), petitcrawler.SimHash(edited) ); d > petitcrawler.DEFAULT_NEAR_DUP_DISTANCE {
+        t.Fatalf("TestSimHash() failed: near identical texts are %d bits apart.", d)
+    }
+    if d := petitcrawler.HammingDistance( petitcrawler.SimHash(text), petitcrawler.SimHash( strings.Join(other, " ") ) ); d < 16 {
+        t.Fatalf("TestSimHash() failed: different texts are only %d bits apart.", d)
+    }
+    if petitcrawler.SimHash("Same  TEXT") != petitcrawler.SimHash("same text") {
+        t.Fatalf("TestSimHash() failed: case and whitespace should not change the fingerprint.")
+    }
+
+    // 0 and 1 are 2 bits apart, 1 and 2 are 3, 3 is far from all
+    fingerprints := []uint64{ 0x0, 0x3, 0x3 | 0x7 << 40, ^uint64(0), 0x0 }
+    clusters := petitcrawler.ClusterSimHashes( fingerprints, 3 )
+    if fmt.Sprint(clusters) != "[[0 1 2 4]]" {
+        t.Fatalf("TestSimHash() failed: Expecting one cluster of 0, 1, 2 and 4, got %v.", clusters)
+    }
+    if clusters = petitcrawler.ClusterSimHashes( fingerprints, 2 ); fmt.Sprint(clusters) != "[[0 1 4]]" {
+        t.Fatalf("TestSimHash() failed: Expecting one cluster of 0, 1 and 4 within 2 bits, got %v.", clusters)
+    }
+    if clusters = petitcrawler.ClusterSimHashes( fingerprints, -1 ); clusters != nil {
+        t.Fatalf("TestSimHash() failed: Expecting no clusters with a negative distance, got %v.", clusters)
+    }
+}
@@ -0,0 +1,218 @@
+package petitcrawler_test
+
+
+import (
+    "petitcrawler"
+    "testing"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+
+var robotsExample = `
+# Example robots.txt
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /search?
+Crawl-delay: 2
+
+User-agent: petitcrawler
+User-agent: otherbot
+Disallow: /secret
+Allow: /secret/ok
+Crawl-delay: 0.5
+
+Sitemap: http://example.com/sitemap.xml
+`
+
+
+// Unit test ParseRobots and Allowed with precedence, wildcards and user agent groups
+func TestRobotsAllowed(t *testing.T) {
+    robots := petitcrawler.ParseRobots( strings.NewReader(robotsExample) )
+
+    cases := []struct {
+        agent string
+        path string
+        allowed bool
+    }{
+        { "somebot", "/", true },
+        { "somebot", "/private/page", false },
+        { "somebot", "/private/public/page", true },
+        { "somebot", "/files/doc.pdf", false },
+        { "somebot", "/files/doc.pdf?x=1", true },
+        { "somebot", "/search?q=go", false },
+        { "somebot", "/search", true },
+        { "somebot", "/secret", true },
+        { "somebot", "/robots.txt", true },
+        { "petitcrawler/1.0", "/private/page", true },
+        { "petitcrawler/1.0", "/secret/page", false },
+        { "petitcrawler/1.0", "/secret/ok", true },
+        { "OtherBot", "/secretive", false },
+    }
+    for i, c := range cases {
+        u, _ := url.Parse( "http://example.com" + c.path )
+        if robots.Allowed( c.agent, u ) != c.allowed {
+            t.Fatalf("TestRobotsAllowed() Failed: Test case %d, %s %s should be allowed=%t.", i+1, c.agent, c.path, c.allowed)
+        }
+    }
+
+    if d, ok := robots.CrawlDelay("somebot"); ok == false || d != 2*time.Second {
+        t.Fatalf("TestRobotsAllowed() Failed: Expecting crawl delay 2s, got %s.", d)
+    }
+    if d, ok := robots.CrawlDelay("petitcrawler"); ok == false || d != 500*time.Millisecond {
+        t.Fatalf("TestRobotsAllowed() Failed: Expecting crawl delay 0.5s, got %s.", d)
+    }
+    if len(robots.Sitemaps) != 1 || robots.Sitemaps[0] != "http://example.com/sitemap.xml" {
+        t.Fatalf("TestRobotsAllowed() Failed: Expecting 1 sitemap, got %v.", robots.Sitemaps)
+    }
+}
+
+
+// Unit test a crawl skips URLs disallowed by robots.txt and records them, unless robots is ignored
+func TestRunRobots(t *testing.T) {
+    var mu sync.Mutex
+    requested := make( map[string]bool )
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        mu.Lock()
+        requested[r.URL.Path] = true
+        mu.Unlock()
+        if r.URL.Path == "/robots.txt" {
+            fmt.Fprint( w, "User-agent: *\nDisallow: /private\n" )
+            return
+        }
+        fmt.Fprintf( w, `<html><body><img src="%s.png"><a href="/a">a</a><a href="/private">p</a></body></html>`, r.URL.Path )
+    }))
+    defer ts.Close()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunRobots() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunRobots() failed: %s", err)
+    }
+    if requested["/private"] {
+        t.Fatalf("TestRunRobots() failed: requested a page disallowed by robots.txt.")
+    }
+    if c.Excluded[ts.URL + "/private"] != "robots.txt" {
+        t.Fatalf("TestRunRobots() failed: Expecting /private to be excluded by robots.txt, got %v.", c.Excluded)
+    }
+
+    c, err = petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithIgnoreRobots(true),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunRobots() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunRobots() failed: %s", err)
+    }
+    if requested["/private"] == false || len(c.Excluded) != 0 {
+        t.Fatalf("TestRunRobots() failed: Expecting /private to be crawled when ignoring robots.txt.")
+    }
+}
+
+
+// Unit test a crawl obeys the robots.txt of each host in scope, and its Crawl-delay
+func TestRunRobotsPerHost(t *testing.T) {
+    var mu sync.Mutex
+    requested := make( map[string]bool )
+    var times []time.Time
+    other := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        mu.Lock()
+        requested[r.URL.Path] = true
+        if r.URL.Path != "/robots.txt" {
+            times = append( times, time.Now() )
+        }
+        mu.Unlock()
+        if r.URL.Path == "/robots.txt" {
+            fmt.Fprint( w, "User-agent: *\nDisallow: /secret\nCrawl-delay: 0.2\n" )
+            return
+        }
+        fmt.Fprint( w, `<html><body>other</body></html>` )
+    }))
+    defer other.Close()
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        if r.URL.Path == "/robots.txt" {
+            w.WriteHeader( http.StatusNotFound )
+            return
+        }
+        fmt.Fprintf( w, `<html><body><a href="/secret">s</a><a href="%s/a">a</a><a href="%s/b">b</a><a href="%s/secret">s</a></body></html>`,
+            other.URL, other.URL, other.URL )
+    }))
+    defer ts.Close()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(4),
+        petitcrawler.WithScope( petitcrawler.ScopeAllowList, strings.TrimPrefix( other.URL, "http://" ) ),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunRobotsPerHost() Failed to create crawler. %s.", err)
+    }
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunRobotsPerHost() failed: %s", err)
+    }
+    mu.Lock()
+    defer mu.Unlock()
+    if c.NumPages != 4 || requested["/secret"] || c.Excluded[other.URL + "/secret"] != "robots.txt" {
+        t.Fatalf("TestRunRobotsPerHost() failed: Expecting only the other host's /secret excluded, got %d pages and %v.", c.NumPages, c.Excluded)
+    }
+    if len(times) != 2 || times[1].Sub(times[0]) < 190*time.Millisecond {
+        t.Fatalf("TestRunRobotsPerHost() failed: Expecting the other host's Crawl-delay kept between its 2 pages, got %v.", times)
+    }
+}
+
+
+// Unit test a slow robots.txt on one host doesn't hold up crawling the others
+func TestRunRobotsSlowHost(t *testing.T) {
+    release := make( chan bool )
+    var once sync.Once
+    other := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        if r.URL.Path == "/robots.txt" {
+            select {
+                case <- release:
+                case <- r.Context().Done():
+                    return
+            }
+            w.WriteHeader( http.StatusNotFound )
+            return
+        }
+        fmt.Fprint( w, `<html><body>other</body></html>` )
+    }))
+    defer other.Close()
+    ts := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+        switch r.URL.Path {
+            case "/robots.txt":
+                w.WriteHeader( http.StatusNotFound )
+            case "/":
+                fmt.Fprintf( w, `<html><body><a href="%s/x">x</a><a href="/a">a</a></body></html>`, other.URL )
+            case "/a":
+                fmt.Fprint( w, `<html><body><a href="/b">b</a></body></html>` )
+            default:
+                // Only reached if the crawl went on while the other host's robots.txt was being fetched
+                once.Do( func() { close(release) } )
+                fmt.Fprint( w, `<html><body>b</body></html>` )
+        }
+    }))
+    defer ts.Close()
+
+    c, err := petitcrawler.NewSingleCrawler( ts.URL, petitcrawler.WithNumWorkers(2), petitcrawler.WithTimeout(5*time.Second),
+        petitcrawler.WithScope( petitcrawler.ScopeAllowList, strings.TrimPrefix( other.URL, "http://" ) ),
+        petitcrawler.WithFilename( t.TempDir() + "/sitemap.txt" ) )
+    if err != nil {
+        t.Fatalf("TestRunRobotsSlowHost() Failed to create crawler. %s.", err)
+    }
+    t0 := time.Now()
+    if err = c.Run(); err != nil {
+        t.Fatalf("TestRunRobotsSlowHost() failed: %s", err)
+    }
+    if c.NumPages != 4 || time.Since(t0) > 4*time.Second {
+        t.Fatalf("TestRunRobotsSlowHost() failed: Expecting 4 pages without waiting on robots.txt, got %d pages in %s.", c.NumPages, time.Since(t0))
+    }
+}
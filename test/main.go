@@ -2,23 +2,245 @@ package main
 
 import (
     "petitcrawler"
+    "crypto/tls"
+    "flag"
     "os"
+    "os/signal"
     "fmt"
+    "strings"
+    "syscall"
+    "time"
+    "github.com/golang/glog"
 )
 
+
+// Set up custom flags from command line
+var MaxpPtr= flag.Int( "maxprint", petitcrawler.DEFAULT_PRINT_LIMIT, "Maximum number of assests/children to print")
+var UrlPtr = flag.String("url", "", "Starting URL to crawl. This is mandatory.")
+var MaxcPtr = flag.Int("maxcrawl", petitcrawler.DEFAULT_MAX_PAGES, "Maximum number of pages to collect. Default 500.")
+var MaxdPtr = flag.Int("maxdepth", petitcrawler.DEFAULT_MAX_DEPTH, "Maximum number of links away from the starting URL to crawl. Default no limit.")
+var MaxtPtr = flag.Int("maxtime", int(petitcrawler.DEFAULT_MAX_TIME/time.Second), "Max time in seconds to crawl for. Default 3 minutes.")
+var HelpPtr = flag.Bool("help", false, "Help text." )
+var OutfilePtr = flag.String("filename", "", "Specify a file to write the sitemap to. Default is <domain name>.<format extension> .")
+var FormatPtr = flag.String("format", "text", "Format to write the sitemap in: text, json (one document), jsonl (JSON Lines, written as pages are collected), csv/tsv (pages, links and assets tables), dot/graphml (link graph), or report (HTML report for people).")
+var CollapsePtr = flag.Int("collapse", 0, "Group pages by their first N path segments in dot/graphml link graphs, for large sites. Default a node per page.")
+var NumwPtr = flag.Int("numworkers", petitcrawler.DEFAULT_NUM_WORKERS, "The number of worker processes we spawn. Default is 100")
+var TimeoutPtr = flag.Int("timeout", int(petitcrawler.DEFAULT_TIMEOUT/time.Second), "Timeout in seconds for a single request. Default 10 seconds.")
+var CafilePtr = flag.String("cafile", "", "PEM file of extra CA certificates to trust, ex: for a staging site behind an internal CA.")
+var MintlsPtr = flag.String("mintls", "", "Minimum TLS version to accept: 1.0, 1.1, 1.2 or 1.3.")
+var ProxyPtr = flag.String("proxy", "", "Send all requests through this proxy URL.")
+var MaxidlePtr = flag.Int("maxidleconns", 0, "Maximum number of idle connections to keep open. Default is the net/http default.")
+var UseragentPtr = flag.String("useragent", petitcrawler.DEFAULT_USER_AGENT, "User-Agent to send, and to match robots.txt rules against.")
+var RatePtr = flag.Float64("rate", petitcrawler.DEFAULT_RATE_LIMIT, "Maximum requests per second to each host, 0 for no limit. Default 10.")
+var BurstPtr = flag.Int("burst", petitcrawler.DEFAULT_BURST, "Number of requests allowed at once before the rate limit applies. Default 5.")
+var MindelayPtr = flag.Int("mindelay", 0, "Minimum delay in milliseconds between requests to a host. Its robots.txt Crawl-delay is used if longer.")
+var RetriesPtr = flag.Int("attempts", petitcrawler.DEFAULT_RETRY_POLICY.MaxAttempts, "Maximum attempts per URL, retrying timeouts and server errors with exponential backoff. Default 3.")
+var DropparamsPtr = flag.String("dropparams", strings.Join(petitcrawler.DEFAULT_TRACKING_PARAMS, ","), "Comma separated query parameters to drop from URLs, a trailing * matches any suffix.")
+var FoldslashPtr = flag.Bool("foldslash", false, "Treat URLs with and without a trailing slash as the same page.")
+var FoldindexPtr = flag.Bool("foldindex", false, "Treat /dir/index.html as the same page as /dir/.")
+var ScopePtr = flag.String("scope", "www", "Which hosts to crawl: exact (only the start host), www (start host with or without www.), subdomains (all subdomains of the domain) or allowlist.")
+var StoragePtr = flag.String("storagedir", "", "Directory to keep the crawl's frontier, visited URLs and pages in, instead of memory, for very large sites.")
+var CheckpointPtr = flag.String("checkpoint", "", "State file to keep the crawl in and checkpoint it to, so it can be continued with -resume.")
+var CheckpointeveryPtr = flag.Int("checkpointevery", int(petitcrawler.DEFAULT_CHECKPOINT_EVERY/time.Second), "Time in seconds between checkpoints. Default 30 seconds.")
+var ResumePtr = flag.String("resume", "", "State file of an interrupted crawl to continue, made with -checkpoint. -url is not needed, and the scope, include/exclude, normalization and depth options of the interrupted crawl are used.")
+var StrategyPtr = flag.String("strategy", "bfs", "Order to crawl links in: bfs (breadth-first), dfs (depth-first) or best (shortest paths first).")
+var DedupPtr = flag.String("dedup", "url", "What makes two pages duplicates: url (same normalized URL), body (same response body) or text (same visible text).")
+var NeardupPtr = flag.Int("neardup", petitcrawler.DEFAULT_NEAR_DUP_DISTANCE, "Report pages whose text fingerprints differ by at most this many bits (0-63) as near duplicates, -1 for no report. Default 6.")
+var HostsPtr = flag.String("hosts", "", "Comma separated hosts to crawl with -scope allowlist, on top of the start host.")
+var GracePtr = flag.Int("grace", 10, "Time in seconds to let pages being crawled finish after Ctrl-C (SIGINT) or SIGTERM. Default 10 seconds.")
+var HeadersPtr = flag.String("headers", strings.Join(petitcrawler.DEFAULT_PAGE_HEADERS, ","), "Comma separated response headers to record with each page. The XML sitemap's <lastmod> needs Last-Modified.")
+var XmlsitemapPtr = flag.String("xmlsitemap", "", "Also write an XML sitemap (sitemaps.org) to this file, split with a sitemap index if it's too big.")
+var SitemapbasePtr = flag.String("sitemapbase", "", "URL the XML sitemap files will be served from, for the sitemap index. Default is the site's root.")
+var ChangefreqPtr = flag.String("changefreq", "", "<changefreq> of every URL in the XML sitemap, ex: weekly. Default none.")
+var PriorityPtr = flag.Float64("priority", -1, "<priority> of every URL in the XML sitemap, 0.0 to 1.0. Default none.")
+var GzipPtr = flag.Bool("gzip", false, "Gzip the XML sitemap files.")
+var IgnorerobotsPtr = flag.Bool("ignorerobots", false, "Don't fetch or obey robots.txt. Only use this on your own sites!")
+
+
+// Include and exclude rules, -include and -exclude can be given more than once
+var Includes ruleList
+var Excludes ruleList
+
+func init() {
+    flag.Var(&Includes, "include", "Only crawl URLs matching this rule: a path prefix (/docs/), glob:<pattern> or regexp:<expression>. Can be repeated.")
+    flag.Var(&Excludes, "exclude", "Never crawl URLs matching this rule: a path prefix (/search), glob:<pattern> or regexp:<expression>. Can be repeated.")
+}
+
+
+// ruleList collects a repeated command line flag
+type ruleList []string
+
+func ( r *ruleList ) String() string {
+    return strings.Join( *r, " " )
+}
+
+func ( r *ruleList ) Set( value string ) error {
+    *r = append( *r, value )
+    return nil
+}
+
+
+// TLS versions accepted by -mintls
+var tlsVersions = map[string]uint16{
+    "1.0": tls.VersionTLS10,
+    "1.1": tls.VersionTLS11,
+    "1.2": tls.VersionTLS12,
+    "1.3": tls.VersionTLS13,
+}
+
+
 func main() {
 
-    Mycrawler, err := petitcrawler.New()
+    flag.Parse()
+    if (*UrlPtr == "" && *ResumePtr == "") || *HelpPtr == true {
+        printHelp()
+        flag.Usage()
+        os.Exit(1)
+    }
+
+    opts := []petitcrawler.Option{
+        petitcrawler.WithPrintLimit(*MaxpPtr),
+        petitcrawler.WithMaxPages(*MaxcPtr),
+        petitcrawler.WithMaxTime(time.Duration(*MaxtPtr)*time.Second),
+        petitcrawler.WithMaxDepth(*MaxdPtr),
+        petitcrawler.WithNearDupDistance(*NeardupPtr),
+        petitcrawler.WithNumWorkers(*NumwPtr),
+        petitcrawler.WithFilename(*OutfilePtr),
+        petitcrawler.WithFormat(*FormatPtr),
+        petitcrawler.WithGraphCollapse(*CollapsePtr),
+        petitcrawler.WithTimeout(time.Duration(*TimeoutPtr)*time.Second),
+        petitcrawler.WithUserAgent(*UseragentPtr),
+        petitcrawler.WithPageHeaders(strings.Split(*HeadersPtr, ",")...),
+        petitcrawler.WithIgnoreRobots(*IgnorerobotsPtr),
+        petitcrawler.WithRateLimit(*RatePtr, *BurstPtr),
+        petitcrawler.WithMinDelay(time.Duration(*MindelayPtr)*time.Millisecond),
+    }
+    policy := petitcrawler.DEFAULT_RETRY_POLICY
+    policy.MaxAttempts = *RetriesPtr
+    opts = append( opts, petitcrawler.WithRetryPolicy(policy) )
+
+    normalizer := petitcrawler.DEFAULT_NORMALIZER
+    normalizer.TrackingParams = nil
+    for _, param := range strings.Split(*DropparamsPtr, ",") {
+        if param = strings.TrimSpace(param); param != "" {
+            normalizer.TrackingParams = append( normalizer.TrackingParams, param )
+        }
+    }
+    normalizer.FoldTrailingSlash = *FoldslashPtr
+    normalizer.FoldIndex = *FoldindexPtr
+    opts = append( opts, petitcrawler.WithNormalizer(normalizer) )
+
+    mode, err := petitcrawler.ParseScopeMode(*ScopePtr)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    var hosts []string
+    if *HostsPtr != "" {
+        hosts = strings.Split(*HostsPtr, ",")
+    }
+    opts = append( opts, petitcrawler.WithScope(mode, hosts...) )
+
+    strategy, err := petitcrawler.ParseStrategy(*StrategyPtr)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    dedup, err := petitcrawler.ParseDedupMode(*DedupPtr)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    if *ResumePtr == "" {
+        // A resumed crawl keeps the strategy and dedup mode it was started with
+        opts = append( opts, petitcrawler.WithStrategy(strategy), petitcrawler.WithDedup(dedup) )
+    }
+    opts = append( opts, petitcrawler.WithInclude(Includes...), petitcrawler.WithExclude(Excludes...) )
+    if *CafilePtr != "" {
+        opts = append( opts, petitcrawler.WithRootCAs(*CafilePtr) )
+    }
+    if *MintlsPtr != "" {
+        version, ok := tlsVersions[*MintlsPtr]
+        if ok == false {
+            fmt.Println("Unknown TLS version: ", *MintlsPtr)
+            os.Exit(1)
+        }
+        opts = append( opts, petitcrawler.WithMinTLSVersion(version) )
+    }
+    if *ProxyPtr != "" {
+        opts = append( opts, petitcrawler.WithProxy(*ProxyPtr) )
+    }
+    if *MaxidlePtr > 0 {
+        opts = append( opts, petitcrawler.WithMaxIdleConns(*MaxidlePtr) )
+    }
+    if *StoragePtr != "" {
+        opts = append( opts, petitcrawler.WithStorageDir(*StoragePtr) )
+    }
+
+    var Mycrawler *petitcrawler.SingleCrawler
+    if *ResumePtr != "" {
+        every := time.Duration(*CheckpointeveryPtr)*time.Second
+        Mycrawler, err = petitcrawler.Resume( *ResumePtr, append( opts, petitcrawler.WithCheckpoint(*ResumePtr, every) )... )
+    } else {
+        if *CheckpointPtr != "" {
+            opts = append( opts, petitcrawler.WithCheckpoint(*CheckpointPtr, time.Duration(*CheckpointeveryPtr)*time.Second) )
+        }
+        Mycrawler, err = petitcrawler.NewSingleCrawler( *UrlPtr, opts... )
+    }
     if err != nil {
         fmt.Println("Failed to create crawler, error is: ", err)
         os.Exit(1)
     }
 
+    // On SIGINT/SIGTERM stop crawling, and still write the sitemap (and checkpoint). A second signal exits now.
+    signals := make( chan os.Signal, 2 )
+    signal.Notify( signals, syscall.SIGINT, syscall.SIGTERM )
+    go func() {
+        sig := <- signals
+        grace := time.Duration(*GracePtr)*time.Second
+        fmt.Printf("\nReceived %s, stopping. Waiting up to %s for pages being crawled, send it again to exit now.\n", sig, grace)
+        Mycrawler.Stop( grace )
+        sig = <- signals
+        fmt.Printf("\nReceived %s again, exiting without writing the sitemap.\n", sig)
+        glog.Flush()
+        os.Exit(1)
+    }()
+
 //    err = Mycrawler.Start()
     err = Mycrawler.Run()
     if err !=nil {
         fmt.Println("Failed to run crawler, error is: ", err)
+        Mycrawler.Close()
+        os.Exit(1)
+    }
+    if *XmlsitemapPtr != "" {
+        files, err := Mycrawler.WriteSitemap( *XmlsitemapPtr, petitcrawler.SitemapOptions{ BaseURL: *SitemapbasePtr,
+            ChangeFreq: *ChangefreqPtr, Priority: *PriorityPtr, HasPriority: *PriorityPtr >= 0, Gzip: *GzipPtr } )
+        if err != nil {
+            fmt.Println("Failed to write XML sitemap, error is: ", err)
+            Mycrawler.Close()
+            os.Exit(1)
+        }
+        fmt.Println("Wrote XML sitemap: ", strings.Join(files, ", "))
+    }
+    if err = Mycrawler.Close(); err != nil {
+        fmt.Println("Failed to close crawler storage, error is: ", err)
         os.Exit(1)
     }
     //err = Mycrawler.Print()
 }
+
+
+// printHelp prints information to the user when it was requested with cmd line flag help
+func printHelp() {
+
+    fmt.Print("\n---- Welcome to PetitCrawler! A single domain web crawler implemented in Golang! ----\n\n\n")
+    fmt.Print("Example: ./Webcrawler -url http://www.urltocrawl.com\n\n")
+    fmt.Print("This program was designed to crawl a single domain.\n\n")
+    fmt.Print("The input to the program is a single URL in a format similar to: 'http://www.exampleurlnotreal.com'. Please follow this format as closely as possible to prevent any errors in crawling.\n\n")
+    fmt.Print("There are some options that you can configure via command line, shown below. The program uses glog package to log any errors it encounters, exiting on fatal ones.\n\n")
+    fmt.Print("The errors are very descriptive, and if you have an issue, you should be able to pinpoint what happened from the log.\n\n")
+    fmt.Print("The output to the program is the site map of the single domain crawled, for each link crawled we display the: (1) URL, (2) static assets, (3) children links found on page.\n\n")
+    fmt.Println("Thank you for using my program! If you have any suggestions for improvement, they are very welcome!")
+
+}